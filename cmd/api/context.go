@@ -2,13 +2,22 @@ package main
 
 import (
 	"context"
-	"github.com/laldil/greenlight/internal/data"
 	"net/http"
+
+	"github.com/laldil/greenlight/internal/data"
+	"github.com/laldil/greenlight/internal/jwt"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey     = contextKey("user")
+	tokenContextKey    = contextKey("token")
+	apiKeyContextKey   = contextKey("apiKey")
+	apiKeyOKContextKey = contextKey("apiKeyOK")
+	claimsContextKey   = contextKey("claims")
+	tenantContextKey   = contextKey("tenant")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -23,3 +32,68 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// contextSetToken stores the plaintext authentication token the request was
+// made with, so that handlers can revoke or identify the current session.
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// contextGetToken returns the plaintext authentication token for the request,
+// or an empty string for anonymous requests.
+func (app *application) contextGetToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	return token
+}
+
+func (app *application) contextSetAPIKey(r *http.Request, apiKey *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, apiKey)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was authenticated with, or
+// nil if it wasn't made with one.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	apiKey, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return apiKey
+}
+
+// contextAllowAPIKey marks the request's route as one that API keys may use.
+func (app *application) contextAllowAPIKey(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyOKContextKey, true)
+	return r.WithContext(ctx)
+}
+
+// contextAPIKeyAllowed reports whether the request's route accepts API keys.
+func (app *application) contextAPIKeyAllowed(r *http.Request) bool {
+	ok, _ := r.Context().Value(apiKeyOKContextKey).(bool)
+	return ok
+}
+
+func (app *application) contextSetClaims(r *http.Request, claims *jwt.Claims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// contextGetClaims returns the claims of the signed access token the request was
+// authenticated with, or nil if it wasn't made with one.
+func (app *application) contextGetClaims(r *http.Request) *jwt.Claims {
+	claims, _ := r.Context().Value(claimsContextKey).(*jwt.Claims)
+	return claims
+}
+
+func (app *application) contextSetTenant(r *http.Request, organization *data.Organization) *http.Request {
+	ctx := context.WithValue(r.Context(), tenantContextKey, organization)
+	return r.WithContext(ctx)
+}
+
+// contextGetTenant returns the organization that the request is for.
+func (app *application) contextGetTenant(r *http.Request) *data.Organization {
+	organization, ok := r.Context().Value(tenantContextKey).(*data.Organization)
+	if !ok {
+		panic("missing tenant value in request context")
+	}
+
+	return organization
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/laldil/greenlight/internal/data"
+)
+
+// Browser clients can keep their session in cookies rather than in JavaScript
+// storage. They ask for this by sending "X-Session: cookie" when logging in, and
+// then send the CSRF token back in the X-CSRF-Token header on every request
+// that changes something.
+const (
+	sessionCookieName = "session"
+	csrfCookieName    = "csrf_token"
+	csrfHeaderName    = "X-CSRF-Token"
+)
+
+func (app *application) wantsSessionCookie(r *http.Request) bool {
+	return app.config.auth.cookies && r.Header.Get("X-Session") == "cookie"
+}
+
+// csrfTokenFor derives the CSRF token for a session. Only someone who can read
+// the HttpOnly session cookie, or the response to the login request, can know
+// it, so another site can't forge it.
+func csrfTokenFor(sessionPlaintext string) string {
+	sum := sha256.Sum256([]byte("csrf:" + sessionPlaintext))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (app *application) validCSRFToken(r *http.Request, sessionPlaintext string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	expected := csrfTokenFor(sessionPlaintext)
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeaderName)), []byte(expected)) == 1
+}
+
+// setSessionCookies stores the session token in an HttpOnly cookie, and its
+// CSRF token in a cookie that the client's JavaScript can read after a reload.
+func (app *application) setSessionCookies(w http.ResponseWriter, token *data.Token) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token.Plaintext,
+		Path:     "/",
+		Expires:  token.Expiry,
+		HttpOnly: true,
+		Secure:   app.config.env == "production",
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    csrfTokenFor(token.Plaintext),
+		Path:     "/",
+		Expires:  token.Expiry,
+		Secure:   app.config.env == "production",
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func (app *application) clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{sessionCookieName, csrfCookieName} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			Expires:  time.Unix(0, 0),
+			MaxAge:   -1,
+			HttpOnly: name == sessionCookieName,
+			Secure:   app.config.env == "production",
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
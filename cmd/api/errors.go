@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -64,11 +67,23 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) invalidCSRFTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or missing CSRF token"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+func (app *application) loginLockedResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -77,8 +92,13 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	message := "your user account must be activated to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
-func (app *application) notAdminResponse(w http.ResponseWriter, r *http.Request) {
-	message := "your user account must be admin to access this resource"
+func (app *application) accountDisabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been disabled by an administrator"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
@@ -86,3 +106,13 @@ func (app *application) notUserResponse(w http.ResponseWriter, r *http.Request)
 	message := "your account must be user to leave message, admin can delete or read users' messages"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) ingredientInUseResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this ingredient is used by one or more foods, remove it from them before deleting it"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) lastAdminResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this is the organization's only active admin, make another user an admin first"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
@@ -3,8 +3,8 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"time"
 
 	data2 "github.com/laldil/greenlight/internal/data"
 	"github.com/laldil/greenlight/internal/validator"
@@ -12,10 +12,13 @@ import (
 
 func (app *application) createFoodHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title    string   `json:"title"`
-		Price    int32    `json:"price"`
-		Waittime int32    `json:"waittime"`
-		Recipe   []string `json:"recipe"`
+		Title       string         `json:"title"`
+		Price       int32          `json:"price"`
+		Waittime    int32          `json:"waittime"`
+		Available   *bool          `json:"available"`
+		Schedule    data2.Schedule `json:"schedule"`
+		Ingredients []int64        `json:"ingredients"`
+		Categories  []int64        `json:"categories"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -24,11 +27,23 @@ func (app *application) createFoodHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	user := app.contextGetUser(r)
+
 	food := &data2.Food{
-		Title:    input.Title,
-		Price:    input.Price,
-		Waittime: input.Waittime,
-		Recipe:   input.Recipe,
+		OrganizationID: app.contextGetTenant(r).ID,
+		Title:          input.Title,
+		Price:          input.Price,
+		Waittime:       input.Waittime,
+		Available:      true,
+		Schedule:       input.Schedule,
+		Ingredients:    input.Ingredients,
+		Categories:     input.Categories,
+		CreatedBy:      &user.ID,
+		UpdatedBy:      &user.ID,
+	}
+
+	if input.Available != nil {
+		food.Available = *input.Available
 	}
 
 	v := validator.New()
@@ -40,7 +55,16 @@ func (app *application) createFoodHandler(w http.ResponseWriter, r *http.Request
 
 	err = app.models.Foods.Insert(food)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data2.ErrUnknownIngredient):
+			v.AddError("ingredients", "must only contain existing ingredients")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data2.ErrUnknownCategory):
+			v.AddError("categories", "must only contain existing categories")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -60,7 +84,7 @@ func (app *application) showFoodHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	food, err := app.models.Foods.Get(id)
+	food, err := app.models.Foods.Get(app.contextGetTenant(r).ID, id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data2.ErrRecordNotFound):
@@ -84,10 +108,10 @@ func (app *application) updateFoodHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	food, err := app.models.Foods.Get(id)
+	food, err := app.models.Foods.Get(app.contextGetTenant(r).ID, id)
 	if err != nil {
 		switch {
-		case errors.Is(err, data2.ErrInvalidRuntimeFormat):
+		case errors.Is(err, data2.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -95,11 +119,18 @@ func (app *application) updateFoodHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if !app.requireEditable(w, r, food.CreatedBy) {
+		return
+	}
+
 	var input struct {
-		Title    *string  `json:"title"`
-		Price    *int32   `json:"price"`
-		Waittime *int32   `json:"waittime"`
-		Recipe   []string `json:"recipe"`
+		Title       *string        `json:"title"`
+		Price       *int32         `json:"price"`
+		Waittime    *int32         `json:"waittime"`
+		Available   *bool          `json:"available"`
+		Schedule    data2.Schedule `json:"schedule"`
+		Ingredients []int64        `json:"ingredients"`
+		Categories  []int64        `json:"categories"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -120,10 +151,24 @@ func (app *application) updateFoodHandler(w http.ResponseWriter, r *http.Request
 		food.Waittime = *input.Waittime
 	}
 
-	if input.Recipe != nil {
-		food.Recipe = input.Recipe
+	if input.Available != nil {
+		food.Available = *input.Available
 	}
 
+	if input.Schedule != nil {
+		food.Schedule = input.Schedule
+	}
+
+	if input.Ingredients != nil {
+		food.Ingredients = input.Ingredients
+	}
+
+	if input.Categories != nil {
+		food.Categories = input.Categories
+	}
+
+	food.UpdatedBy = &app.contextGetUser(r).ID
+
 	v := validator.New()
 
 	if data2.ValidateFood(v, food); !v.Valid() {
@@ -134,6 +179,12 @@ func (app *application) updateFoodHandler(w http.ResponseWriter, r *http.Request
 	err = app.models.Foods.Update(food)
 	if err != nil {
 		switch {
+		case errors.Is(err, data2.ErrUnknownIngredient):
+			v.AddError("ingredients", "must only contain existing ingredients")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data2.ErrUnknownCategory):
+			v.AddError("categories", "must only contain existing categories")
+			app.failedValidationResponse(w, r, v.Errors)
 		case errors.Is(err, data2.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -148,6 +199,67 @@ func (app *application) updateFoodHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// updateFoodAvailabilityHandler switches a food on or off the menu, such as when
+// it sells out. Unlike a full update it doesn't need the food's version, but the
+// same people who may edit the food are the ones who may use it.
+func (app *application) updateFoodAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Available *bool `json:"available"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Available != nil, "available", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	food, err := app.models.Foods.Get(app.contextGetTenant(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data2.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.requireEditable(w, r, food.CreatedBy) {
+		return
+	}
+
+	food.UpdatedBy = &app.contextGetUser(r).ID
+
+	err = app.models.Foods.SetAvailable(food, *input.Available)
+	if err != nil {
+		switch {
+		case errors.Is(err, data2.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"food": food}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) deleteFoodHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -155,7 +267,22 @@ func (app *application) deleteFoodHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Foods.Delete(id)
+	food, err := app.models.Foods.Get(app.contextGetTenant(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data2.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.requireEditable(w, r, food.CreatedBy) {
+		return
+	}
+
+	err = app.models.Foods.Delete(food.OrganizationID, food.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data2.ErrRecordNotFound):
@@ -166,6 +293,13 @@ func (app *application) deleteFoodHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// The food's image records went with it, but their files have to be removed
+	// separately. The food is gone either way, so a failure is only logged.
+	err = app.removeImageFiles(r.Context(), food.Images)
+	if err != nil {
+		app.logError(r, err)
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "food successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -174,8 +308,12 @@ func (app *application) deleteFoodHandler(w http.ResponseWriter, r *http.Request
 
 func (app *application) listFoodsHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Recipe []string
+		Title            string
+		Recipe           []string
+		ExcludeAllergens []string
+		Diets            []string
+		Category         int
+		AvailableNow     bool
 		data2.Filters
 	}
 
@@ -184,17 +322,40 @@ func (app *application) listFoodsHandler(w http.ResponseWriter, r *http.Request)
 
 	input.Title = app.readString(qs, "title", "")
 	input.Recipe = app.readCSV(qs, "recipe", []string{})
+	input.ExcludeAllergens = app.readCSV(qs, "exclude_allergens", []string{})
+	input.Diets = app.readCSV(qs, "diet", []string{})
+	input.Category = app.readInt(qs, "category", 0, v)
+	input.AvailableNow = app.readBool(qs, "available_now", false, v)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	input.Filters.SortSafeList = []string{"id", "title", "price", "waittime", "-id", "-title", "-price", "-waittime"}
 
+	v.Check(input.Category >= 0, "category", "must be a positive integer")
+	data2.ValidateAllergens(v, "exclude_allergens", input.ExcludeAllergens)
+	data2.ValidateDiets(v, "diet", input.Diets)
+
 	if data2.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	foods, metadata, err := app.models.Foods.GetAll(input.Title, input.Recipe, input.Filters)
+	tenant := app.contextGetTenant(r)
+
+	// Schedules are in the restaurant's local time, so that's the time they're
+	// checked against.
+	var availableAt time.Time
+	if input.AvailableNow {
+		location, err := tenant.Location()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		availableAt = time.Now().In(location)
+	}
+
+	foods, metadata, err := app.models.Foods.GetAll(tenant.ID, input.Title, input.Recipe, input.ExcludeAllergens, input.Diets, int64(input.Category), availableAt, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -205,45 +366,3 @@ func (app *application) listFoodsHandler(w http.ResponseWriter, r *http.Request)
 		app.serverErrorResponse(w, r, err)
 	}
 }
-
-func (app *application) ImageHandler(w http.ResponseWriter, r *http.Request) {
-
-	r.ParseMultipartForm(10 * 1024 * 1024)
-
-	file, handler, err := r.FormFile("myfile")
-
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	defer file.Close()
-
-	fmt.Println("File info")
-	fmt.Println("File name:", handler.Filename)
-	fmt.Println("File size:", handler.Size)
-	fmt.Println("File type:", handler.Header.Get("Content-Type"))
-
-	tempFile, err2 := ioutil.TempFile("images", "upload-*.jpg")
-	if err2 != nil {
-		fmt.Println(err2)
-		return
-	}
-	defer tempFile.Close()
-
-	tempFile, err4 := ioutil.TempFile("pdfs", "upload-*.pdf")
-	if err2 != nil {
-		fmt.Println(err4)
-		return
-	}
-	defer tempFile.Close()
-
-	fileBytes, err3 := ioutil.ReadAll(file)
-	if err3 != nil {
-		fmt.Println(err2)
-		return
-	}
-	tempFile.Write(fileBytes)
-	fmt.Println("File uploaded")
-
-}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/laldil/greenlight/internal/data"
+	"github.com/laldil/greenlight/internal/storage"
+	"github.com/laldil/greenlight/internal/thumbnail"
+	"github.com/laldil/greenlight/internal/validator"
+)
+
+// maxImagePixels stops a small, highly compressed upload from decoding into an
+// enormous bitmap. Decoding and flattening an image this size takes around
+// 100MB, and -images-max-concurrent limits how many are in memory at once.
+const maxImagePixels = 12_000_000
+
+// signedImageURLExpiry is how long the direct links to images in the blob store
+// last, for stores that support them.
+const signedImageURLExpiry = time.Hour
+
+// imageContentTypes are the kinds of image that can be uploaded, as sniffed
+// from the file's contents.
+var imageContentTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// uploadFoodImageHandler takes a multipart form with the picture in an "image"
+// field. The original is kept as uploaded, and a JPEG thumbnail is made for
+// each of the data.ThumbnailSizes.
+func (app *application) uploadFoodImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	food, err := app.models.Foods.Get(app.contextGetTenant(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.requireEditable(w, r, food.CreatedBy) {
+		return
+	}
+
+	v := validator.New()
+
+	// The limit on the body leaves some room for the multipart headers.
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.images.maxBytes+64*1024)
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("image must not be larger than %d bytes", app.config.images.maxBytes))
+		case errors.Is(err, http.ErrMissingFile):
+			v.AddError("image", "must be provided")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	original, err := io.ReadAll(io.LimitReader(file, app.config.images.maxBytes+1))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	contentType := http.DetectContentType(original)
+
+	v.Check(int64(len(original)) <= app.config.images.maxBytes, "image", fmt.Sprintf("must not be larger than %d bytes", app.config.images.maxBytes))
+	v.Check(validator.PermittedValue(contentType, imageContentTypes...), "image", "must be a JPEG, PNG or GIF image")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The dimensions are checked before the whole image is decoded.
+	dimensions, _, err := image.DecodeConfig(bytes.NewReader(original))
+	if err == nil && dimensions.Width*dimensions.Height > maxImagePixels {
+		err = errors.New("image has too many pixels")
+	}
+	invalidImage := fmt.Sprintf("must be a valid image of no more than %d megapixels", maxImagePixels/1_000_000)
+	if err != nil {
+		v.AddError("image", invalidImage)
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	files, err := app.makeImageFiles(r.Context(), original)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidImage):
+			v.AddError("image", invalidImage)
+			app.failedValidationResponse(w, r, v.Errors)
+		case r.Context().Err() != nil:
+			// The client gave up while waiting for its turn.
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	foodImage := &data.FoodImage{
+		FoodID:      food.ID,
+		ContentType: contentType,
+		Width:       dimensions.Width,
+		Height:      dimensions.Height,
+	}
+
+	err = app.models.FoodImages.Insert(foodImage)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.saveImageFiles(r.Context(), foodImage.ID, files)
+	if err != nil {
+		if err := app.models.FoodImages.Delete(foodImage.ID); err != nil {
+			app.logError(r, err)
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", data.ImageURL(foodImage.ID, data.ImageOriginal))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"image": foodImage}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showImageHandler serves an image at one of its sizes. An image never changes
+// once uploaded, so clients and proxies may cache it indefinitely.
+func (app *application) showImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	size := httprouter.ParamsFromContext(r.Context()).ByName("size")
+	if _, ok := data.ThumbnailSizes[size]; !ok && size != data.ImageOriginal {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	foodImage, err := app.models.FoodImages.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	contentType := "image/jpeg"
+	if size == data.ImageOriginal {
+		contentType = foodImage.ContentType
+	}
+
+	// Images uploaded before the blob store are still in the old images
+	// directory until they're deleted.
+	blob, err := app.getLegacyImage(r.Context(), foodImage.ID, size)
+	if errors.Is(err, storage.ErrNotFound) {
+		key := imageKey(foodImage.ID, size)
+
+		// Stores that can hand out direct links serve the image themselves.
+		// The redirect is cached for less time than the link lasts.
+		url, err := app.storage.SignedURL(r.Context(), key, signedImageURLExpiry)
+		switch {
+		case err == nil:
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(signedImageURLExpiry.Seconds()/2)))
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		case !errors.Is(err, storage.ErrNotSupported):
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		blob, err = app.storage.Get(r.Context(), key)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer blob.Close()
+
+	contents, err := io.ReadAll(blob)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", fmt.Sprintf(`"%d-%s"`, foodImage.ID, size))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	http.ServeContent(w, r, "", foodImage.CreatedAt, bytes.NewReader(contents))
+}
+
+// errInvalidImage is returned by makeImageFiles for uploads that can't be
+// decoded.
+var errInvalidImage = errors.New("invalid image")
+
+// makeImageFiles decodes an uploaded image and returns the files to store for
+// it, by size name: the original as uploaded and a JPEG thumbnail for each of
+// the data.ThumbnailSizes. Decoded images take far more memory than uploads, so
+// only -images-max-concurrent are worked on at once and the rest wait their
+// turn, or until ctx is done.
+func (app *application) makeImageFiles(ctx context.Context, original []byte) (map[string][]byte, error) {
+	select {
+	case app.imageSlots <- struct{}{}:
+		defer func() { <-app.imageSlots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		return nil, errInvalidImage
+	}
+
+	files := map[string][]byte{data.ImageOriginal: original}
+
+	flat := thumbnail.Flatten(decoded)
+	for size, longest := range data.ThumbnailSizes {
+		var buf bytes.Buffer
+
+		err = thumbnail.Encode(&buf, thumbnail.Resize(flat, longest))
+		if err != nil {
+			return nil, err
+		}
+
+		files[size] = buf.Bytes()
+	}
+
+	return files, nil
+}
+
+// imageKey returns the key an image is stored under at the given size.
+func imageKey(id int64, size string) string {
+	return fmt.Sprintf("images/%d/%s", id, size)
+}
+
+// saveImageFiles stores the files for an image, by size name. If any of them
+// can't be stored, none are kept.
+func (app *application) saveImageFiles(ctx context.Context, id int64, files map[string][]byte) error {
+	for size, contents := range files {
+		err := app.storage.Put(ctx, imageKey(id, size), bytes.NewReader(contents), http.DetectContentType(contents))
+		if err != nil {
+			app.removeImageFiles(ctx, []int64{id})
+			return err
+		}
+	}
+
+	return nil
+}
+
+// legacyImageKey returns the key an image is stored under at the given size in
+// the old images directory.
+func legacyImageKey(id int64, size string) string {
+	return fmt.Sprintf("%d/%s", id, size)
+}
+
+// getLegacyImage opens an image from the old images directory, returning
+// storage.ErrNotFound if it isn't there.
+func (app *application) getLegacyImage(ctx context.Context, id int64, size string) (io.ReadCloser, error) {
+	if app.legacyImages == nil {
+		return nil, storage.ErrNotFound
+	}
+
+	return app.legacyImages.Get(ctx, legacyImageKey(id, size))
+}
+
+// removeImageFiles deletes the stored files of the given images, wherever they
+// are kept.
+func (app *application) removeImageFiles(ctx context.Context, ids []int64) error {
+	for _, id := range ids {
+		for _, size := range imageSizes() {
+			err := app.storage.Delete(ctx, imageKey(id, size))
+			if err != nil {
+				return err
+			}
+
+			if app.legacyImages != nil {
+				err = app.legacyImages.Delete(ctx, legacyImageKey(id, size))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// imageSizes returns the names of every size an image is stored at.
+func imageSizes() []string {
+	sizes := []string{data.ImageOriginal}
+	for size := range data.ThumbnailSizes {
+		sizes = append(sizes, size)
+	}
+	return sizes
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/laldil/greenlight/internal/data"
+	"github.com/laldil/greenlight/internal/validator"
+)
+
+func (app *application) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	data.ValidateStaffRole(v, input.Role)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tenant := app.contextGetTenant(r)
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		// An invitation that was never accepted, perhaps because it expired, can
+		// be sent again. Any account that is in use, or isn't staff, is left alone.
+		if user.Activated || user.Disabled || user.Role == data.RoleUser || user.OrganizationID != tenant.ID {
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		user.Role = input.Role
+
+		err = app.models.Users.UpdateAccess(user, data.PermissionsForRole(user.Role))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		// Only the newest invitation should work.
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeInvitation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	case errors.Is(err, data.ErrRecordNotFound):
+		// The account is created straight away so that the role is fixed by the
+		// admin, not the invitee. It can't be used until the invitation is
+		// accepted, because nobody knows its password and it isn't activated. The
+		// invitee gives their name when they accept.
+		user = &data.User{
+			Email:          input.Email,
+			Activated:      false,
+			Role:           input.Role,
+			OrganizationID: tenant.ID,
+		}
+
+		err = user.Password.SetUnusable()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.models.Users.InsertWithPermissions(user, data.PermissionsForRole(user.Role))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateEmail):
+				v.AddError("email", "a user with this email address already exists")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	default:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 7*24*time.Hour, data.ScopeInvitation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, &data.AuditEvent{
+		Action:   data.AuditInvitation,
+		Outcome:  data.AuditSuccess,
+		TargetID: &user.ID,
+		Detail:   user.Email + " as " + user.Role,
+	})
+
+	inviter := app.contextGetUser(r)
+
+	app.background(func() {
+		data := map[string]any{
+			"invitationToken": token.Plaintext,
+			"inviterName":     inviter.Name,
+			"role":            user.Role,
+		}
+
+		err := app.mailer.Send(user.Email, "user_invitation.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"invitation": map[string]any{
+		"email":  user.Email,
+		"role":   user.Role,
+		"expiry": token.Expiry,
+	}}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/laldil/greenlight/internal/data"
+)
+
+// loginRetryAfter returns how long a client must wait before it may try to log
+// in again as email from ip. Every failed attempt for an email address doubles
+// the delay, starting from the configured backoff. An IP address can be shared
+// by many people, so it never backs off, and is only locked outright once it
+// reaches its own failure limit, as is an email address.
+func (app *application) loginRetryAfter(email, ip string) (time.Duration, error) {
+	var wait time.Duration
+
+	emailKey := data.EmailLoginKey(email)
+
+	for _, key := range []string{emailKey, data.IPLoginKey(ip)} {
+		failure, err := app.models.LoginFailures.Get(key)
+		if err != nil {
+			return 0, err
+		}
+
+		if failure.LockedUntil != nil {
+			if d := time.Until(*failure.LockedUntil); d > wait {
+				wait = d
+			}
+			continue
+		}
+
+		if key == emailKey && failure.Failures > 0 {
+			backoff := app.config.lockout.duration
+			if failure.Failures < 32 && app.config.lockout.backoff<<(failure.Failures-1) < backoff {
+				backoff = app.config.lockout.backoff << (failure.Failures - 1)
+			}
+			if d := time.Until(failure.LastFailureAt.Add(backoff)); d > wait {
+				wait = d
+			}
+		}
+	}
+
+	return wait, nil
+}
+
+// loginAttempt is a login that was counted as failed against its email address
+// and IP address before the credentials were checked, so that requests made in
+// parallel each see their own count.
+type loginAttempt struct {
+	email         string
+	ip            string
+	emailFailures int
+	ipFailures    int
+}
+
+// beginLoginAttempt counts a login against the email address and the client IP.
+// If either goes over its limit, it is locked and the lockout's duration is
+// returned, and the credentials mustn't be checked.
+func (app *application) beginLoginAttempt(email, ip string) (*loginAttempt, time.Duration, error) {
+	window := app.config.lockout.duration
+
+	emailFailure, err := app.models.LoginFailures.Record(data.EmailLoginKey(email), window)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ipFailure, err := app.models.LoginFailures.Record(data.IPLoginKey(ip), window)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	attempt := &loginAttempt{
+		email:         email,
+		ip:            ip,
+		emailFailures: emailFailure.Failures,
+		ipFailures:    ipFailure.Failures,
+	}
+
+	lockedUntil := time.Now().Add(app.config.lockout.duration)
+	locked := false
+
+	if attempt.emailFailures > app.config.lockout.maxFailures {
+		err = app.models.LoginFailures.Lock(emailFailure.Key, lockedUntil)
+		if err != nil {
+			return nil, 0, err
+		}
+		locked = true
+	}
+
+	if attempt.ipFailures > app.config.lockout.ipMaxFailures {
+		err = app.models.LoginFailures.Lock(ipFailure.Key, lockedUntil)
+		if err != nil {
+			return nil, 0, err
+		}
+		locked = true
+	}
+
+	if locked {
+		return nil, app.config.lockout.duration, nil
+	}
+
+	return attempt, 0, nil
+}
+
+// failLoginAttempt locks the email address or IP address of a failed login once
+// it has reached its limit. When an existing account gets locked its owner is
+// told by email.
+func (app *application) failLoginAttempt(attempt *loginAttempt, user *data.User) error {
+	lockedUntil := time.Now().Add(app.config.lockout.duration)
+
+	if attempt.emailFailures >= app.config.lockout.maxFailures {
+		err := app.models.LoginFailures.Lock(data.EmailLoginKey(attempt.email), lockedUntil)
+		if err != nil {
+			return err
+		}
+
+		if user != nil && attempt.emailFailures == app.config.lockout.maxFailures {
+			app.background(func() {
+				data := map[string]any{
+					"failures":    attempt.emailFailures,
+					"ip":          attempt.ip,
+					"lockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+				}
+
+				err := app.mailer.Send(user.Email, "login_lockout.tmpl", data)
+				if err != nil {
+					app.logger.PrintError(err, nil)
+				}
+			})
+		}
+	}
+
+	if attempt.ipFailures >= app.config.lockout.ipMaxFailures {
+		err := app.models.LoginFailures.Lock(data.IPLoginKey(attempt.ip), lockedUntil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// succeedLoginAttempt forgets the earlier failures for the email address, and
+// takes back the failure that the successful login was counted as for the IP
+// address.
+func (app *application) succeedLoginAttempt(attempt *loginAttempt) error {
+	err := app.models.LoginFailures.Delete(data.EmailLoginKey(attempt.email))
+	if err != nil {
+		return err
+	}
+
+	return app.models.LoginFailures.Forgive(data.IPLoginKey(attempt.ip))
+}
+
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetForOrganization(id, app.contextGetTenant(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.LoginFailures.Delete(data.EmailLoginKey(user.Email))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,17 +3,24 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
+	_ "time/tzdata" // Organizations' time zones are checked against this copy of the database.
 
 	"github.com/laldil/greenlight/internal/data"
 	"github.com/laldil/greenlight/internal/jsonlog"
 	"github.com/laldil/greenlight/internal/mailer"
+	"github.com/laldil/greenlight/internal/oidc"
+	"github.com/laldil/greenlight/internal/storage"
+	"github.com/laldil/greenlight/internal/webauthn"
 	_ "github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type config struct {
@@ -37,14 +44,69 @@ type config struct {
 		burst   int
 		enabled bool
 	}
+	auth struct {
+		mode       string
+		jwtSecret  string
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+		cookies    bool
+	}
+	oidc struct {
+		issuer         string
+		clientID       string
+		clientSecret   string
+		redirectURL    string
+		allowedDomains []string
+	}
+	webauthn struct {
+		rpID   string
+		rpName string
+		origin string
+	}
+	password struct {
+		minEntropy float64
+		history    int
+		bcryptCost int
+	}
+	tenant struct {
+		domain string
+	}
+	images struct {
+		dir           string
+		maxBytes      int64
+		maxConcurrent int
+	}
+	storage struct {
+		backend string
+		dir     string
+		s3      struct {
+			endpoint  string
+			region    string
+			bucket    string
+			accessKey string
+			secretKey string
+			pathStyle bool
+		}
+	}
+	lockout struct {
+		maxFailures   int
+		ipMaxFailures int
+		duration      time.Duration
+		backoff       time.Duration
+	}
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config       config
+	logger       *jsonlog.Logger
+	models       data.Models
+	mailer       mailer.Mailer
+	oidc         *oidc.Provider
+	webauthn     *webauthn.RelyingParty
+	storage      storage.BlobStore
+	legacyImages storage.BlobStore
+	imageSlots   chan struct{}
+	wg           sync.WaitGroup
 }
 
 func main() {
@@ -62,6 +124,53 @@ func main() {
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	flag.StringVar(&cfg.auth.mode, "auth-mode", "opaque", "Authentication token mode (opaque|jwt)")
+	flag.StringVar(&cfg.auth.jwtSecret, "jwt-secret", "", "Secret used to sign access tokens in jwt mode")
+	flag.DurationVar(&cfg.auth.accessTTL, "jwt-access-ttl", 15*time.Minute, "Lifetime of signed access tokens")
+	flag.DurationVar(&cfg.auth.refreshTTL, "jwt-refresh-ttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+	flag.BoolVar(&cfg.auth.cookies, "auth-cookies", false, "Allow browser clients to use cookie sessions")
+
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "OpenID Connect issuer URL for staff login (disabled if empty)")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", "", "OpenID Connect client secret")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "http://localhost:4000/v1/oidc/callback", "OpenID Connect redirect URL")
+	flag.Func("oidc-allowed-domains", "Comma-separated email domains whose staff get an account on their first OpenID Connect login (others need an invitation)", func(val string) error {
+		for _, domain := range strings.Split(val, ",") {
+			if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+				cfg.oidc.allowedDomains = append(cfg.oidc.allowedDomains, domain)
+			}
+		}
+		return nil
+	})
+
+	flag.StringVar(&cfg.webauthn.rpID, "webauthn-rp-id", "localhost", "WebAuthn relying party ID (the site's domain)")
+	flag.StringVar(&cfg.webauthn.rpName, "webauthn-rp-name", "Greenlight", "WebAuthn relying party name")
+	flag.StringVar(&cfg.webauthn.origin, "webauthn-origin", "http://localhost:4000", "WebAuthn origin that passkey ceremonies run on")
+
+	flag.Float64Var(&cfg.password.minEntropy, "password-min-entropy", 40, "Minimum estimated strength of new passwords, in bits")
+	flag.IntVar(&cfg.password.history, "password-history", 5, "Number of previous passwords that can't be reused (0 to allow reuse)")
+	flag.IntVar(&cfg.password.bcryptCost, "password-bcrypt-cost", 12, "bcrypt cost for new password hashes")
+
+	flag.StringVar(&cfg.images.dir, "images-dir", "images", "Directory food images were stored in before -storage-dir; images still there are served from it")
+	flag.Int64Var(&cfg.images.maxBytes, "images-max-bytes", 10*1024*1024, "Largest food image that can be uploaded, in bytes")
+	flag.IntVar(&cfg.images.maxConcurrent, "images-max-concurrent", 4, "Number of uploaded images that can be processed at once")
+
+	flag.StringVar(&cfg.storage.backend, "storage-backend", "local", "Where uploads are stored (local|s3)")
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "uploads", "Directory uploads are stored in with the local backend")
+	flag.StringVar(&cfg.storage.s3.endpoint, "s3-endpoint", "https://s3.amazonaws.com", "S3-compatible service URL")
+	flag.StringVar(&cfg.storage.s3.region, "s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&cfg.storage.s3.bucket, "s3-bucket", "", "S3 bucket uploads are stored in")
+	flag.StringVar(&cfg.storage.s3.accessKey, "s3-access-key", "", "S3 access key ID")
+	flag.StringVar(&cfg.storage.s3.secretKey, "s3-secret-key", "", "S3 secret access key")
+	flag.BoolVar(&cfg.storage.s3.pathStyle, "s3-path-style", false, "Put the S3 bucket in the URL path, as MinIO and most other S3-compatible servers need")
+
+	flag.StringVar(&cfg.tenant.domain, "tenant-domain", "", "Base domain whose subdomains select an organization, e.g. greenlight.example.com")
+
+	flag.IntVar(&cfg.lockout.maxFailures, "lockout-max-failures", 5, "Failed logins for one email address before it is locked")
+	flag.IntVar(&cfg.lockout.ipMaxFailures, "lockout-ip-max-failures", 20, "Failed logins from one IP address before it is locked")
+	flag.DurationVar(&cfg.lockout.duration, "lockout-duration", 15*time.Minute, "How long a login lockout lasts")
+	flag.DurationVar(&cfg.lockout.backoff, "lockout-backoff", time.Second, "Delay after the first failed login, doubled after each further failure")
+
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.office365.com", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smpt-port", 587, "SMTP port")
 	flag.StringVar(&cfg.smtp.username, "smtp-username", "211341@astanait.edu.kz", "SMTP username")
@@ -72,6 +181,22 @@ func main() {
 
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	switch {
+	case cfg.auth.mode != "opaque" && cfg.auth.mode != "jwt":
+		logger.PrintFatal(errors.New("auth-mode must be either opaque or jwt"), nil)
+	case cfg.auth.mode == "jwt" && len(cfg.auth.jwtSecret) < 32:
+		logger.PrintFatal(errors.New("jwt-secret must be at least 32 bytes long in jwt mode"), nil)
+	case cfg.password.bcryptCost < bcrypt.MinCost || cfg.password.bcryptCost > bcrypt.MaxCost:
+		logger.PrintFatal(fmt.Errorf("password-bcrypt-cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost), nil)
+	case cfg.storage.backend != "local" && cfg.storage.backend != "s3":
+		logger.PrintFatal(errors.New("storage-backend must be either local or s3"), nil)
+	case cfg.images.maxConcurrent < 1:
+		logger.PrintFatal(errors.New("images-max-concurrent must be at least 1"), nil)
+	}
+
+	data.PasswordCost = cfg.password.bcryptCost
+	data.PasswordMinEntropy = cfg.password.minEntropy
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -79,11 +204,38 @@ func main() {
 	defer db.Close()
 	logger.PrintInfo("database connection pool established", nil)
 
+	store, err := openStorage(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	legacyImages, err := openLegacyImages(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:       cfg,
+		logger:       logger,
+		models:       data.NewModels(db),
+		mailer:       mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage:      store,
+		legacyImages: legacyImages,
+		imageSlots:   make(chan struct{}, cfg.images.maxConcurrent),
+		webauthn: webauthn.New(webauthn.Config{
+			RPID:   cfg.webauthn.rpID,
+			RPName: cfg.webauthn.rpName,
+			Origin: cfg.webauthn.origin,
+		}),
+	}
+
+	if cfg.oidc.issuer != "" {
+		app.oidc = oidc.New(oidc.Config{
+			Issuer:       cfg.oidc.issuer,
+			ClientID:     cfg.oidc.clientID,
+			ClientSecret: cfg.oidc.clientSecret,
+			RedirectURL:  cfg.oidc.redirectURL,
+		})
 	}
 
 	srv := &http.Server{
@@ -130,3 +282,36 @@ func openDB(cfg config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// openStorage returns the blob store that uploads are kept in.
+func openStorage(cfg config) (storage.BlobStore, error) {
+	if cfg.storage.backend == "s3" {
+		return storage.NewS3(storage.S3Config{
+			Endpoint:  cfg.storage.s3.endpoint,
+			Region:    cfg.storage.s3.region,
+			Bucket:    cfg.storage.s3.bucket,
+			AccessKey: cfg.storage.s3.accessKey,
+			SecretKey: cfg.storage.s3.secretKey,
+			PathStyle: cfg.storage.s3.pathStyle,
+		})
+	}
+
+	return storage.NewLocal(cfg.storage.dir)
+}
+
+// openLegacyImages returns a store for the images directory used before uploads
+// moved to the blob store, where images are kept as <id>/<size>. It returns nil
+// if the directory doesn't exist.
+func openLegacyImages(cfg config) (storage.BlobStore, error) {
+	info, err := os.Stat(cfg.images.dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case !info.IsDir():
+		return nil, fmt.Errorf("images-dir %s is not a directory", cfg.images.dir)
+	}
+
+	return storage.NewLocal(cfg.images.dir)
+}
@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	data2 "github.com/laldil/greenlight/internal/data"
+	"github.com/laldil/greenlight/internal/jwt"
 	"github.com/laldil/greenlight/internal/validator"
 	"golang.org/x/time/rate"
 )
@@ -82,12 +84,25 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		authorizationHeader := r.Header.Get("Authorization")
 		if authorizationHeader == "" {
+			if app.config.auth.cookies {
+				w.Header().Add("Vary", "Cookie")
+
+				if cookie, err := r.Cookie(sessionCookieName); err == nil {
+					app.authenticateSessionCookie(w, r, next, cookie.Value)
+					return
+				}
+			}
+
 			r = app.contextSetUser(r, data2.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) == 2 && headerParts[0] == "ApiKey" {
+			app.authenticateAPIKey(w, r, next, headerParts[1])
+			return
+		}
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
@@ -95,6 +110,11 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		token := headerParts[1]
 
+		if app.config.auth.mode == "jwt" && strings.Count(token, ".") == 2 {
+			app.authenticateAccessToken(w, r, next, token)
+			return
+		}
+
 		v := validator.New()
 
 		if data2.ValidateTokenPlaintext(v, token); !v.Valid() {
@@ -102,7 +122,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data2.ScopeAuthentication, token)
+		user, err := app.getUserForSession(token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data2.ErrRecordNotFound):
@@ -112,52 +132,247 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 		next.ServeHTTP(w, r)
 	})
 
 }
 
-func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Use the contextGetUser() helper that we made earlier to retrieve the user
-		// information from the request context.
-		user := app.contextGetUser(r)
-		// If the user is anonymous, then call the authenticationRequiredResponse() to
-		// inform the client that they should authenticate before trying again.
-		if user.IsAnonymous() {
-			app.authenticationRequiredResponse(w, r)
+// getUserForSession looks up the user for an authentication token, and records
+// that the token has been used. Sessions of disabled accounts are treated as
+// not found.
+func (app *application) getUserForSession(token string) (*data2.User, error) {
+	user, err := app.models.Users.GetForToken(data2.ScopeAuthentication, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Disabled {
+		return nil, data2.ErrRecordNotFound
+	}
+
+	err = app.models.Tokens.UpdateLastUsed(data2.ScopeAuthentication, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// authenticateSessionCookie handles requests from browser clients using a cookie
+// session. Browsers send cookies on requests that other sites trigger, so
+// anything other than a safe method must also carry the session's CSRF token.
+func (app *application) authenticateSessionCookie(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	v := validator.New()
+
+	user := data2.AnonymousUser
+	if data2.ValidateTokenPlaintext(v, token); v.Valid() {
+		var err error
+		user, err = app.getUserForSession(token)
+		if err != nil && !errors.Is(err, data2.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
 			return
 		}
-		// If the user is not activated, use the inactiveAccountResponse() helper to
-		// inform them that they need to activate their account.
-		if !user.Activated {
-			app.inactiveAccountResponse(w, r)
-			return
+	}
+
+	// An expired or revoked session just means the client is logged out, so the
+	// stale cookies are removed and the request carries on anonymously.
+	if user == nil || user.IsAnonymous() {
+		app.clearSessionCookies(w)
+		r = app.contextSetUser(r, data2.AnonymousUser)
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	if !app.validCSRFToken(r, token) {
+		app.invalidCSRFTokenResponse(w, r)
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetToken(r, token)
+	next.ServeHTTP(w, r)
+}
+
+// authenticateAPIKey handles requests made with an "Authorization: ApiKey <key>"
+// header. The request runs as the key's owner, with the key stored in the
+// context so that requirePermission can restrict it to the key's permissions.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	v := validator.New()
+
+	if data2.ValidateAPIKeyPlaintext(v, key); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user, apiKey, err := app.models.APIKeys.GetForKey(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data2.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// A disabled owner's keys stop working with them.
+	if user.Disabled {
+		app.accountDisabledResponse(w, r)
+		return
+	}
+
+	err = app.models.APIKeys.UpdateLastUsed(apiKey.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, apiKey)
+	next.ServeHTTP(w, r)
+}
+
+// authenticateAccessToken handles signed access tokens. The token only proves
+// who the user is: their account is loaded from the database on every request,
+// so that a role change, deactivation or deletion takes effect straight away
+// rather than when the token expires.
+func (app *application) authenticateAccessToken(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	claims, err := jwt.Verify(token, []byte(app.config.auth.jwtSecret), time.Now())
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data2.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
-		if user.Role != "admin" {
-			app.notAdminResponse(w, r)
+		return
+	}
+
+	if user.Disabled {
+		app.accountDisabledResponse(w, r)
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetClaims(r, claims)
+	next.ServeHTTP(w, r)
+}
+
+// resolveTenant works out which organization the request is for. Signed-in
+// users always work within their own organization. Anonymous requests, such as
+// customers browsing a menu, pick one with the X-Organization header or a
+// subdomain of the configured tenant domain, and otherwise get the default
+// organization.
+func (app *application) resolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "X-Organization")
+
+		user := app.contextGetUser(r)
+
+		var (
+			organization *data2.Organization
+			err          error
+		)
+
+		switch slug := app.tenantSlug(r); {
+		case !user.IsAnonymous():
+			organization, err = app.models.Organizations.Get(user.OrganizationID)
+		case slug != "":
+			organization, err = app.models.Organizations.GetBySlug(slug)
+		default:
+			organization, err = app.models.Organizations.Get(data2.DefaultOrganizationID)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, data2.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
 			return
 		}
-		// Call the next handler in the chain.
+
+		r = app.contextSetTenant(r, organization)
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (app *application) requireActivated(next http.HandlerFunc) http.HandlerFunc {
+// tenantSlug returns the organization named by the request, if any.
+func (app *application) tenantSlug(r *http.Request) string {
+	if slug := r.Header.Get("X-Organization"); slug != "" {
+		return slug
+	}
+
+	if app.config.tenant.domain == "" {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	suffix := "." + strings.ToLower(app.config.tenant.domain)
+	host = strings.ToLower(host)
+	if !strings.HasSuffix(host, suffix) {
+		return ""
+	}
+
+	slug := strings.TrimSuffix(host, suffix)
+	if strings.Contains(slug, ".") {
+		return ""
+	}
+
+	return slug
+}
+
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Use the contextGetUser() helper that we made earlier to retrieve the user
-		// information from the request context.
 		user := app.contextGetUser(r)
-		// If the user is anonymous, then call the authenticationRequiredResponse() to
-		// inform the client that they should authenticate before trying again.
+
+		// Anonymous requests aren't audited, since anyone could fill the audit log
+		// with them.
 		if user.IsAnonymous() {
 			app.authenticationRequiredResponse(w, r)
 			return
 		}
+
+		// API keys are for machines doing specific jobs, so they can only be
+		// used on routes that opt in with allowAPIKey. Everything else, such as
+		// account settings and managing the keys themselves, needs the user.
+		if app.contextGetAPIKey(r) != nil && !app.contextAPIKeyAllowed(r) {
+			app.auditAccessDenied(r, "api key not accepted")
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
 		// If the user is not activated, use the inactiveAccountResponse() helper to
 		// inform them that they need to activate their account.
 		if !user.Activated {
+			app.auditAccessDenied(r, "not activated")
 			app.inactiveAccountResponse(w, r)
 			return
 		}
@@ -165,4 +380,51 @@ func (app *application) requireActivated(next http.HandlerFunc) http.HandlerFunc
 		// Call the next handler in the chain.
 		next.ServeHTTP(w, r)
 	})
+
+	// Wrap fn with the requireAuthenticatedUser() middleware before returning it,
+	// so anonymous users are asked to authenticate first.
+	return app.requireAuthenticatedUser(fn)
+}
+
+// allowAPIKey lets requests authenticated with an API key through the
+// authentication checks of next.
+func (app *application) allowAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, app.contextAllowAPIKey(r))
+	}
+}
+
+// requirePermission only lets activated users through if they have been granted
+// the given permission code, such as "foods:write". API keys are accepted, as
+// long as the permission is one of theirs.
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		// The permissions in a signed access token may be out of date, so they
+		// are always looked up.
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include(code) {
+			app.auditAccessDenied(r, "missing "+code)
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		// API keys can only use the subset of their owner's permissions that was
+		// chosen when the key was created.
+		if apiKey := app.contextGetAPIKey(r); apiKey != nil && !apiKey.Permissions.Include(code) {
+			app.auditAccessDenied(r, "api key missing "+code)
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.allowAPIKey(app.requireActivatedUser(fn))
 }
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/laldil/greenlight/internal/data"
+	"github.com/laldil/greenlight/internal/oidc"
+)
+
+// oidcCookieName is the cookie that carries the state, nonce and PKCE code
+// verifier from the start of a login to its callback.
+const oidcCookieName = "oidc_flow"
+
+func (app *application) startOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		value, err := oidc.GenerateRandom()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := app.oidc.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The provider sends the user back with a top-level GET, so SameSite=Lax is
+	// enough for the cookie to come along.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    strings.Join(values[:], "."),
+		Path:     "/v1/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   app.config.env == "production",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("missing or expired login attempt, please start again"))
+		return
+	}
+
+	// The flow cookie is single-use.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Path:     "/v1/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   app.config.env == "production",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	values := strings.Split(cookie.Value, ".")
+	if len(values) != 3 {
+		app.badRequestResponse(w, r, errors.New("missing or expired login attempt, please start again"))
+		return
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	qs := r.URL.Query()
+
+	if qs.Get("error") != "" {
+		app.badRequestResponse(w, r, errors.New("identity provider error: "+qs.Get("error")))
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(qs.Get("state")), []byte(state)) != 1 {
+		app.badRequestResponse(w, r, errors.New("invalid state parameter"))
+		return
+	}
+
+	idToken, err := app.oidc.Exchange(r.Context(), qs.Get("code"), verifier, nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidIDToken):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Accounts are matched on email address, so only addresses the provider has
+	// verified can be trusted.
+	if idToken.Email == "" || !idToken.EmailVerified {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(idToken.Email)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		// Anyone the provider vouches for would otherwise become staff, so new
+		// accounts are only made for the organization's own email domains.
+		// Everyone else needs an invitation, which creates their account.
+		if !app.oidcDomainAllowed(idToken.Email) {
+			app.audit(r, &data.AuditEvent{Action: data.AuditRegistration, Outcome: data.AuditFailure, ActorEmail: idToken.Email, Detail: "oidc domain not allowed"})
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		user, err = app.createOIDCUser(idToken, app.contextGetTenant(r).ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.auditUser(r, data.AuditRegistration, data.AuditSuccess, user, "oidc")
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	case user.Disabled:
+		// Signing in through the provider doesn't undo an admin disabling the
+		// account.
+		app.auditUser(r, data.AuditLogin, data.AuditFailure, user, "oidc disabled")
+		app.notPermittedResponse(w, r)
+		return
+	case !user.Activated:
+		// The owner never confirmed their email address, and the provider has
+		// just verified it, which is all that activation would have done.
+		// Whoever registered the account didn't necessarily own the address, so
+		// any password they chose is discarded.
+		err = user.Password.SetUnusable()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		user.Activated = true
+
+		err = app.models.Users.Update(user)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		app.auditUser(r, data.AuditActivation, data.AuditSuccess, user, "oidc")
+	}
+
+	// Users who have enrolled in two-factor authentication still need a code.
+	app.completeLogin(w, r, user)
+}
+
+// oidcDomainAllowed reports whether the email address is in one of the domains
+// that can sign up through the identity provider.
+func (app *application) oidcDomainAllowed(email string) bool {
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return false
+	}
+
+	domain = strings.ToLower(domain)
+	for _, allowed := range app.config.oidc.allowedDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// createOIDCUser creates the account for a staff member signing in through the
+// identity provider for the first time, in the organization the login was
+// started from. They never get a usable password.
+func (app *application) createOIDCUser(idToken *oidc.IDToken, orgID int64) (*data.User, error) {
+	name := idToken.Name
+	if name == "" {
+		name = idToken.Email
+	}
+
+	user := &data.User{
+		Name:           name,
+		Email:          idToken.Email,
+		Activated:      true,
+		Role:           data.RoleStaff,
+		OrganizationID: orgID,
+	}
+
+	err := user.Password.SetUnusable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Users.InsertWithPermissions(user, data.PermissionsForRole(user.Role))
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
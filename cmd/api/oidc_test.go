@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestOIDCDomainAllowed(t *testing.T) {
+	app := &application{}
+	app.config.oidc.allowedDomains = []string{"example.com", "staff.example.org"}
+
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"alice@example.com", true},
+		{"Alice@EXAMPLE.com", true},
+		{"bob@staff.example.org", true},
+		{"mallory@example.com.evil.net", false},
+		{"mallory@evilexample.com", false},
+		{"mallory@sub.example.com", false},
+		{"no-at-sign", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := app.oidcDomainAllowed(tt.email); got != tt.want {
+			t.Errorf("oidcDomainAllowed(%q) = %t; want %t", tt.email, got, tt.want)
+		}
+	}
+
+	app.config.oidc.allowedDomains = nil
+	if app.oidcDomainAllowed("alice@example.com") {
+		t.Error("got a domain allowed with no allowed domains configured")
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/laldil/greenlight/internal/data"
+	"github.com/laldil/greenlight/internal/validator"
+	"github.com/laldil/greenlight/internal/webauthn"
+)
+
+// passkeyCeremonyTTL is how long the browser has to complete a passkey
+// registration or login once it has been given the options.
+const passkeyCeremonyTTL = 5 * time.Minute
+
+func (app *application) listPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	passkeys, err := app.models.Passkeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"passkeys": passkeys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPasskeyOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	// Like two-factor authentication, passkeys are offered to staff accounts
+	// only, and can't be added with an API key.
+	if user.Role == data.RoleUser || app.contextGetAPIKey(r) != nil {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	passkeys, err := app.models.Passkeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasskeyRegistration, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	token, err := app.models.Tokens.New(user.ID, passkeyCeremonyTTL, data.ScopePasskeyRegistration)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Existing passkeys are excluded so the same authenticator isn't registered
+	// twice.
+	var excludeIDs [][]byte
+	for _, passkey := range passkeys {
+		excludeIDs = append(excludeIDs, passkey.CredentialID)
+	}
+
+	options := app.webauthn.CreationOptions([]byte(token.Plaintext), webauthn.User{
+		ID:          []byte(strconv.FormatInt(user.ID, 10)),
+		Name:        user.Email,
+		DisplayName: user.Name,
+	}, excludeIDs)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"public_key": options}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name              string          `json:"name"`
+		ClientDataJSON    webauthn.Buffer `json:"client_data_json"`
+		AttestationObject webauthn.Buffer `json:"attestation_object"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if user.Role == data.RoleUser || app.contextGetAPIKey(r) != nil {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	passkey := &data.Passkey{
+		UserID: user.ID,
+		Name:   input.Name,
+	}
+
+	v := validator.New()
+
+	if data.ValidatePasskey(v, passkey); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The challenge that the browser signed tells us which registration this
+	// response is for.
+	clientData, err := webauthn.ParseClientData(input.ClientDataJSON)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	challengeUser, err := app.models.Users.GetForToken(data.ScopePasskeyRegistration, string(clientData.Challenge))
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if challengeUser == nil || challengeUser.ID != user.ID {
+		v.AddError("challenge", "invalid or expired, please start again")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	credential, err := app.webauthn.VerifyRegistration(clientData.Challenge, input.ClientDataJSON, input.AttestationObject)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasskeyRegistration, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	passkey.CredentialID = credential.ID
+	passkey.PublicKey = credential.PublicKey
+	passkey.SignCount = int64(credential.SignCount)
+
+	err = app.models.Passkeys.Insert(passkey)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatePasskey):
+			v.AddError("passkey", "this passkey has already been registered")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"passkey": passkey}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Passkeys.DeleteForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "passkey successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPasskeyLoginOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var allowIDs [][]byte
+	if user != nil && user.Activated && !user.Disabled {
+		passkeys, err := app.models.Passkeys.GetAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		for _, passkey := range passkeys {
+			allowIDs = append(allowIDs, passkey.CredentialID)
+		}
+	}
+
+	// Unknown addresses, and accounts without passkeys, get a random challenge
+	// that was never stored and so can never verify.
+	var challenge []byte
+	if len(allowIDs) > 0 {
+		token, err := app.models.Tokens.New(user.ID, passkeyCeremonyTTL, data.ScopePasskeyLogin)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		challenge = []byte(token.Plaintext)
+	} else {
+		challenge = make([]byte, 16)
+		_, err = rand.Read(challenge)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	options := app.webauthn.RequestOptions(challenge, allowIDs)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"public_key": options}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPasskeyAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input passkeyAssertion
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	clientData, err := webauthn.ParseClientData(input.ClientDataJSON)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopePasskeyLogin, string(clientData.Challenge))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.audit(r, &data.AuditEvent{Action: data.AuditLogin, Outcome: data.AuditFailure, Detail: "passkey unknown challenge"})
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	valid, err := app.verifyPasskeyAssertion(user, clientData, input)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !valid {
+		app.auditUser(r, data.AuditLogin, data.AuditFailure, user, "passkey invalid assertion")
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	if app.refuseDisabledLogin(w, r, user) {
+		return
+	}
+
+	// A passkey already proves both possession and user verification, so there
+	// is no second factor to ask for.
+	app.sendAuthenticationToken(w, r, user)
+}
+
+// passkeyAssertion is a passkey's response to a challenge from
+// createPasskeyLoginOptionsHandler.
+type passkeyAssertion struct {
+	CredentialID      webauthn.Buffer `json:"credential_id"`
+	ClientDataJSON    webauthn.Buffer `json:"client_data_json"`
+	AuthenticatorData webauthn.Buffer `json:"authenticator_data"`
+	Signature         webauthn.Buffer `json:"signature"`
+}
+
+// verifyPasskeyAssertion checks that one of the user's passkeys signed the
+// challenge in clientData, which must already have been matched to the user.
+// On success the passkey's signature counter is updated and the user's login
+// challenges are used up. It returns false if the assertion doesn't verify.
+func (app *application) verifyPasskeyAssertion(user *data.User, clientData *webauthn.ClientData, input passkeyAssertion) (bool, error) {
+	passkey, err := app.models.Passkeys.GetForCredentialID(input.CredentialID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	credential := webauthn.Credential{
+		ID:        passkey.CredentialID,
+		PublicKey: passkey.PublicKey,
+		SignCount: uint32(passkey.SignCount),
+	}
+
+	signCount, err := app.webauthn.VerifyAssertion(clientData.Challenge, credential, input.ClientDataJSON, input.AuthenticatorData, input.Signature)
+	if err != nil {
+		if errors.Is(err, webauthn.ErrSignCount) {
+			app.logger.PrintInfo("passkey signature counter went backwards", map[string]string{
+				"user_id":    strconv.FormatInt(user.ID, 10),
+				"passkey_id": strconv.FormatInt(passkey.ID, 10),
+			})
+		}
+		return false, nil
+	}
+
+	err = app.models.Passkeys.UpdateSignCount(passkey, int64(signCount))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasskeyLogin, user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/laldil/greenlight/internal/data"
+)
+
+// canEdit reports whether the user may change or delete a food or sale that
+// was created by createdBy. Managers and admins may edit anything in their
+// organization; everyone else may only edit what they created themselves.
+// Records with no known creator can only be edited by managers and admins.
+func (app *application) canEdit(user *data.User, createdBy *int64) bool {
+	switch user.Role {
+	case data.RoleManager, data.RoleAdmin:
+		return true
+	}
+
+	return createdBy != nil && *createdBy == user.ID
+}
+
+// requireEditable checks the edit policy for the current user, sending a
+// 403 Forbidden response and returning false if they may only read the record.
+func (app *application) requireEditable(w http.ResponseWriter, r *http.Request, createdBy *int64) bool {
+	if !app.canEdit(app.contextGetUser(r), createdBy) {
+		app.auditAccessDenied(r, "not owner")
+		app.notPermittedResponse(w, r)
+		return false
+	}
+
+	return true
+}
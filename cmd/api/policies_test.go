@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/laldil/greenlight/internal/data"
+)
+
+func TestEditPolicyWithDefaultPermissions(t *testing.T) {
+	app := &application{}
+
+	var ownerID, otherID int64 = 1, 2
+
+	tests := []struct {
+		name      string
+		role      string
+		createdBy *int64
+		want      bool
+	}{
+		{"staff, own record", data.RoleStaff, &ownerID, true},
+		{"staff, someone else's record", data.RoleStaff, &otherID, false},
+		{"staff, unknown creator", data.RoleStaff, nil, false},
+		{"manager, own record", data.RoleManager, &ownerID, true},
+		{"manager, someone else's record", data.RoleManager, &otherID, true},
+		{"manager, unknown creator", data.RoleManager, nil, true},
+		{"admin, someone else's record", data.RoleAdmin, &otherID, true},
+		{"admin, unknown creator", data.RoleAdmin, nil, true},
+		{"customer, own record", data.RoleUser, &ownerID, false},
+		{"customer, someone else's record", data.RoleUser, &otherID, false},
+	}
+
+	for _, tt := range tests {
+		user := &data.User{ID: ownerID, Role: tt.role}
+		permissions := data.PermissionsForRole(tt.role)
+
+		// An edit has to get past requirePermission before the edit policy is
+		// checked at all.
+		for _, code := range []string{"foods:write", "sales:write"} {
+			got := permissions.Include(code) && app.canEdit(user, tt.createdBy)
+			if got != tt.want {
+				t.Errorf("%s, %s: got %t; want %t", tt.name, code, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestStaffCanCreateByDefault(t *testing.T) {
+	permissions := data.PermissionsForRole(data.RoleStaff)
+
+	for _, code := range []string{"foods:write", "sales:write"} {
+		if !permissions.Include(code) {
+			t.Errorf("got staff without %s", code)
+		}
+	}
+	if permissions.Include("users:manage") {
+		t.Error("got staff with users:manage")
+	}
+}
@@ -13,23 +13,98 @@ func (app *application) routes() http.Handler {
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
 	router.HandlerFunc(http.MethodGet, "/v1/foods", app.listFoodsHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/foods", app.requireActivatedUser(app.createFoodHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/foods", app.requirePermission("foods:write", app.createFoodHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/foods/:id", app.showFoodHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/foods/:id", app.requireActivatedUser(app.updateFoodHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/foods/:id", app.requireActivatedUser(app.deleteFoodHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/foods/:id", app.requirePermission("foods:write", app.updateFoodHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/foods/:id", app.requirePermission("foods:write", app.deleteFoodHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/foods/:id/availability", app.requirePermission("foods:write", app.updateFoodAvailabilityHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/foods/:id/images", app.requirePermission("foods:write", app.uploadFoodImageHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/images/:id/:size", app.showImageHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/ingredients", app.listIngredientsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/ingredients", app.requirePermission("foods:write", app.createIngredientHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/ingredients/:id", app.showIngredientHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/ingredients/:id", app.requirePermission("foods:write", app.updateIngredientHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/ingredients/:id", app.requirePermission("foods:write", app.deleteIngredientHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/categories", app.listCategoriesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/categories", app.requirePermission("foods:write", app.createCategoryHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/categories/:id", app.showCategoryHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/categories/:id", app.requirePermission("foods:write", app.updateCategoryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/categories/:id", app.requirePermission("foods:write", app.deleteCategoryHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/menu", app.showMenuHandler)
 
 	router.HandlerFunc(http.MethodGet, "/v1/sales", app.listSalesHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/sales", app.requireActivatedUser(app.createSaleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/sales", app.requirePermission("sales:write", app.createSaleHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/sales/:id", app.showSaleHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/sales/:id", app.requireActivatedUser(app.updateSaleHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/sales/:id", app.requireActivatedUser(app.deleteSaleHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/sales/:id", app.requirePermission("sales:write", app.updateSaleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/sales/:id", app.requirePermission("sales:write", app.deleteSaleHandler))
 
+	router.HandlerFunc(http.MethodGet, "/v1/users", app.requirePermission("users:manage", app.listUsersHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:id", app.usersRoute(app.requireActivatedUser(app.updateCurrentUserHandler), app.requirePermission("users:manage", app.updateUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id", app.usersRoute(app.requireAuthenticatedUser(app.deleteCurrentUserHandler), app.requirePermission("users:manage", app.deleteUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/lockout", app.requirePermission("users:manage", app.unlockUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/totp", app.requireActivatedUser(app.enrollTOTPHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/totp", app.requireActivatedUser(app.enableTOTPHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/totp", app.usersRoute(app.requireActivatedUser(app.deleteCurrentUserTOTPHandler), app.requirePermission("users:manage", app.resetUserTOTPHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/invited", app.registerInvitedUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireAuthenticatedUser(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireAuthenticatedUser(app.exportCurrentUserHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/api-keys", app.requireActivatedUser(app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/api-keys", app.requireActivatedUser(app.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/api-keys/:id", app.requireActivatedUser(app.deleteAPIKeyHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/passkeys", app.requireActivatedUser(app.listPasskeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/passkeys/options", app.requireActivatedUser(app.createPasskeyOptionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/passkeys", app.requireActivatedUser(app.createPasskeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/passkeys/:id", app.requireActivatedUser(app.deletePasskeyHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/organization", app.showOrganizationHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/organization", app.requirePermission("users:manage", app.updateOrganizationHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/upload", app.ImageHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/audit-events", app.requirePermission("users:manage", app.listAuditEventsHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/invitations", app.requirePermission("users:manage", app.createInvitationHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/login", app.startOIDCLoginHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/callback", app.oidcCallbackHandler)
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/:id", app.requireAuthenticatedUser(app.deleteSessionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.createRefreshedTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/refresh", app.deleteRefreshTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link", app.createMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication/magic-link", app.createMagicLinkAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/passkey", app.createPasskeyLoginOptionsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication/passkey", app.createPasskeyAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", app.createMFAAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	return app.recoverPanic(app.rateLimit(app.authenticate(app.resolveTenant(router))))
+}
+
+// usersRoute lets the self-service /v1/users/me routes share a path with the
+// admin /v1/users/:id routes. httprouter won't register both for the same
+// method, so requests for "me" are passed to self and the rest to byID.
+func (app *application) usersRoute(self, byID http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if params.ByName("id") == "me" {
+			self(w, r)
+			return
+		}
 
-	return app.recoverPanic(app.rateLimit(app.authenticate(router)))
+		byID(w, r)
+	}
 }
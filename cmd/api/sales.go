@@ -22,11 +22,16 @@ func (app *application) createSaleHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	user := app.contextGetUser(r)
+
 	sale := &data2.Sale{
-		Title:       input.Title,
-		Description: input.Description,
-		Duration:    input.Duration,
-		Foodsale:    input.Foodsale,
+		OrganizationID: app.contextGetTenant(r).ID,
+		Title:          input.Title,
+		Description:    input.Description,
+		Duration:       input.Duration,
+		Foodsale:       input.Foodsale,
+		CreatedBy:      &user.ID,
+		UpdatedBy:      &user.ID,
 	}
 
 	v := validator.New()
@@ -58,7 +63,7 @@ func (app *application) showSaleHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	sale, err := app.models.Sales.Get(id)
+	sale, err := app.models.Sales.Get(app.contextGetTenant(r).ID, id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data2.ErrRecordNotFound):
@@ -82,10 +87,10 @@ func (app *application) updateSaleHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	sale, err := app.models.Sales.Get(id)
+	sale, err := app.models.Sales.Get(app.contextGetTenant(r).ID, id)
 	if err != nil {
 		switch {
-		case errors.Is(err, data2.ErrInvalidRuntimeFormat):
+		case errors.Is(err, data2.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -93,6 +98,10 @@ func (app *application) updateSaleHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if !app.requireEditable(w, r, sale.CreatedBy) {
+		return
+	}
+
 	var input struct {
 		Title       *string        `json:"title"`
 		Description *string        `json:"description"`
@@ -122,6 +131,8 @@ func (app *application) updateSaleHandler(w http.ResponseWriter, r *http.Request
 		sale.Foodsale = input.Foodsale
 	}
 
+	sale.UpdatedBy = &app.contextGetUser(r).ID
+
 	v := validator.New()
 
 	if data2.ValidateSale(v, sale); !v.Valid() {
@@ -153,7 +164,22 @@ func (app *application) deleteSaleHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Sales.Delete(id)
+	sale, err := app.models.Sales.Get(app.contextGetTenant(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data2.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.requireEditable(w, r, sale.CreatedBy) {
+		return
+	}
+
+	err = app.models.Sales.Delete(sale.OrganizationID, sale.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data2.ErrRecordNotFound):
@@ -192,8 +218,7 @@ func (app *application) listSalesHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	sales, metadata, err := app.models.Sales.GetAll(input.Title, input.Foodsale, input.Filters)
-	fmt.Println(sales[1].Title)
+	sales, metadata, err := app.models.Sales.GetAll(app.contextGetTenant(r).ID, input.Title, input.Foodsale, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
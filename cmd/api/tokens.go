@@ -1,12 +1,15 @@
 package main
 
 import (
+	"encoding/hex"
 	"errors"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
-	"github.com/laldil/greenlight/internal/data"
 	data2 "github.com/laldil/greenlight/internal/data"
+	"github.com/laldil/greenlight/internal/jwt"
 	"github.com/laldil/greenlight/internal/validator"
 )
 
@@ -24,11 +27,44 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	// Validate the email and password provided by the client.
 	v := validator.New()
 	data2.ValidateEmail(v, input.Email)
-	//data.ValidatePasswordPlaintext(v, input.Password)
+	// Only the presence and length of the password are checked, so that users
+	// whose passwords predate the current rules can still log in.
+	v.Check(input.Password != "", "password", "must be provided")
+	v.Check(len(input.Password) <= 72, "password", "must not be more than 72 bytes long")
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	// Refuse to check the password at all while the email address or the client's
+	// IP address is backing off or locked out after earlier failures.
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	retryAfter, err := app.loginRetryAfter(input.Email, ip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.audit(r, &data2.AuditEvent{Action: data2.AuditLogin, Outcome: data2.AuditFailure, ActorEmail: input.Email, Detail: "locked out"})
+		app.loginLockedResponse(w, r, retryAfter)
+		return
+	}
+	// The attempt is counted as a failure before the password is checked, and
+	// taken back if it turns out to be right, so that guesses sent in parallel
+	// can't all get in under the limit.
+	attempt, retryAfter, err := app.beginLoginAttempt(input.Email, ip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if attempt == nil {
+		app.audit(r, &data2.AuditEvent{Action: data2.AuditLogin, Outcome: data2.AuditFailure, ActorEmail: input.Email, Detail: "locked out"})
+		app.loginLockedResponse(w, r, retryAfter)
+		return
+	}
 	// Lookup the user record based on the email address. If no matching user was
 	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
 	// Unauthorized response to the client (we will create this helper in a moment).
@@ -36,6 +72,12 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	if err != nil {
 		switch {
 		case errors.Is(err, data2.ErrRecordNotFound):
+			app.audit(r, &data2.AuditEvent{Action: data2.AuditLogin, Outcome: data2.AuditFailure, ActorEmail: input.Email, Detail: "unknown email"})
+			err = app.failLoginAttempt(attempt, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -52,12 +94,112 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	//If the passwords don't match, then we call the app.invalidCredentialsResponse()
 	//helper again and return.
 	if !match {
+		app.auditUser(r, data2.AuditLogin, data2.AuditFailure, user, "wrong password")
+		err = app.failLoginAttempt(attempt, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
-	// Otherwise, if the password is correct, we generate a new token with a 24-hour
-	// expiry time and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// The password was right, so forget any earlier failures for this account.
+	err = app.succeedLoginAttempt(attempt)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// This is the only time we have the plaintext password, so it's when hashes
+	// made with an older, cheaper bcrypt cost get upgraded.
+	if user.Password.NeedsRehash() {
+		err = app.rehashPassword(user, input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	app.completeLogin(w, r, user)
+}
+
+// rehashPassword stores a new hash of the user's unchanged password. Losing a
+// race with another update to the user doesn't matter, as the rehash will just
+// happen on their next login instead.
+func (app *application) rehashPassword(user *data2.User, plaintext string) error {
+	err := user.Password.Set(plaintext)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil && !errors.Is(err, data2.ErrEditConflict) {
+		return err
+	}
+
+	return nil
+}
+
+// completeLogin is called once a user has proven who they are. Users with
+// two-factor authentication enabled get a short-lived "mfa pending" token, which
+// they exchange for an authentication token at POST /v1/tokens/mfa; everyone
+// else gets their authentication token straight away.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data2.User) {
+	if app.refuseDisabledLogin(w, r, user) {
+		return
+	}
+
+	totp, err := app.models.TOTP.Get(user.ID)
+	if err != nil && !errors.Is(err, data2.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if totp != nil && totp.Enabled {
+		token, err := app.models.Tokens.New(user.ID, 5*time.Minute, data2.ScopeMFAPending)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusAccepted, envelope{"mfa_pending_token": token}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.sendAuthenticationToken(w, r, user)
+}
+
+// refuseDisabledLogin ends the login of a user whose account an admin has
+// disabled, and reports whether it did. It is only called once the user has
+// proven who they are, so that it doesn't tell anyone else about the account.
+func (app *application) refuseDisabledLogin(w http.ResponseWriter, r *http.Request, user *data2.User) bool {
+	if !user.Disabled {
+		return false
+	}
+
+	app.auditUser(r, data2.AuditLogin, data2.AuditFailure, user, "disabled")
+	app.accountDisabledResponse(w, r)
+	return true
+}
+
+// sendAuthenticationToken issues a new authentication token for the user and
+// sends it to the client. In jwt mode that is a signed access token plus a
+// refresh token instead. Browser clients that asked for a cookie session get
+// the token in a cookie, whatever the mode.
+func (app *application) sendAuthenticationToken(w http.ResponseWriter, r *http.Request, user *data2.User) {
+	app.auditUser(r, data2.AuditLogin, data2.AuditSuccess, user, "")
+
+	if app.wantsSessionCookie(r) {
+		app.sendSessionCookie(w, r, user)
+		return
+	}
+	if app.config.auth.mode == "jwt" {
+		app.sendAccessToken(w, r, user, nil)
+		return
+	}
+	// Generate a new token with a 24-hour expiry time and the scope
+	// 'authentication'.
+	token, err := app.models.Tokens.NewSession(user.ID, 24*time.Hour, r.UserAgent())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -69,3 +211,588 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// sendSessionCookie starts a cookie session. The token itself never appears in
+// the response body, only the CSRF token that has to accompany it.
+func (app *application) sendSessionCookie(w http.ResponseWriter, r *http.Request, user *data2.User) {
+	token, err := app.models.Tokens.NewSession(user.ID, 24*time.Hour, r.UserAgent())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setSessionCookies(w, token)
+
+	env := envelope{"session": map[string]any{
+		"csrf_token": csrfTokenFor(token.Plaintext),
+		"expiry":     token.Expiry,
+	}}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// The client sends the pending token from the first login step along with
+	// either a code from their authenticator app or one of their recovery codes.
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data2.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data2.ScopeMFAPending, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data2.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Wrong codes count as failed logins, so guessing codes is slowed down and
+	// eventually locked out just like guessing passwords.
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	retryAfter, err := app.loginRetryAfter(user.Email, ip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.loginLockedResponse(w, r, retryAfter)
+		return
+	}
+	attempt, retryAfter, err := app.beginLoginAttempt(user.Email, ip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if attempt == nil {
+		app.loginLockedResponse(w, r, retryAfter)
+		return
+	}
+
+	valid, err := app.checkSecondFactor(user.ID, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !valid {
+		app.auditUser(r, data2.AuditLogin, data2.AuditFailure, user, "wrong second factor")
+		err = app.failLoginAttempt(attempt, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.succeedLoginAttempt(attempt)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data2.ScopeMFAPending, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The account may have been disabled since the first step.
+	if app.refuseDisabledLogin(w, r, user) {
+		return
+	}
+
+	app.sendAuthenticationToken(w, r, user)
+}
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse and validate the user's email address.
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data2.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// The response is the same whether or not the address belongs to an activated
+	// account that hasn't been disabled, so this endpoint can't be used to find
+	// out who has signed up.
+	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data2.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !user.Activated || user.Disabled {
+		err = app.writeJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Otherwise, create a new password reset token with a 45-minute expiry time.
+	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data2.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Email the user with their password reset token.
+	app.background(func() {
+		data := map[string]any{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data2.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// As with password resets, the response doesn't say whether the address
+	// belongs to an activated account.
+	env := envelope{"message": "an email will be sent to you containing a login link"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data2.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !user.Activated || user.Disabled {
+		err = app.writeJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Login links are short-lived, and only the newest one works.
+	err = app.models.Tokens.DeleteAllForUser(data2.ScopeMagicLink, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	token, err := app.models.Tokens.New(user.ID, 15*time.Minute, data2.ScopeMagicLink)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"magicLinkToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "token_magic_link.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createMagicLinkAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data2.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data2.ScopeMagicLink, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data2.ErrRecordNotFound):
+			app.audit(r, &data2.AuditEvent{Action: data2.AuditLogin, Outcome: data2.AuditFailure, Detail: "magic link invalid or expired"})
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// The link is single-use, so delete it before handing out a token.
+	err = app.models.Tokens.DeleteAllForUser(data2.ScopeMagicLink, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Following the link proves the user can read their email, which is as good
+	// as a correct password, so any earlier failed logins are forgotten.
+	err = app.models.LoginFailures.Delete(data2.EmailLoginKey(user.Email))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse and validate the user's email address.
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data2.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// As with password resets, an unknown address gets the same response as a
+	// known one.
+	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data2.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Disabled accounts stay off until an admin turns them back on.
+	if user.Disabled {
+		app.accountDisabledResponse(w, r)
+		return
+	}
+	// Staff accounts are only activated by accepting their invitation, where the
+	// invitee sets their name and password, so they are treated like unknown
+	// addresses here. An admin can send the invitation again instead.
+	if user.Role != data2.RoleUser {
+		err = app.writeJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Return an error if the user has already been activated.
+	if user.Activated {
+		v.AddError("email", "user has already been activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Only the newest activation token should work, so delete any old ones before
+	// creating a replacement.
+	err = app.models.Tokens.DeleteAllForUser(data2.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data2.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Email the user with their new activation token.
+	app.background(func() {
+		data := map[string]any{
+			"activationToken": token.Plaintext,
+			"userID":          user.ID,
+		}
+
+		err := app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// A signed access token can't be revoked, it just expires, so signing out
+	// with one ends the login it was issued for by revoking its refresh tokens.
+	if claims := app.contextGetClaims(r); claims != nil {
+		family, err := hex.DecodeString(claims.Session)
+		if err != nil || len(family) == 0 {
+			app.badRequestResponse(w, r, errors.New("this access token can't be used to sign out, revoke its refresh token at DELETE /v1/tokens/refresh instead"))
+			return
+		}
+
+		err = app.models.Tokens.DeleteRefreshFamilyForUser(family, app.contextGetUser(r).ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		// Revoke the token that this request was authenticated with.
+		err := app.models.Tokens.Delete(data2.ScopeAuthentication, app.contextGetToken(r))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if app.config.auth.cookies {
+		app.clearSessionCookies(w)
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "you have been signed out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(user.ID, app.contextGetToken(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Tokens.DeleteSessionForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data2.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sendAccessToken issues a signed access token and a new refresh token in the
+// given family (nil for a fresh login), and sends both to the client.
+func (app *application) sendAccessToken(w http.ResponseWriter, r *http.Request, user *data2.User, family []byte) {
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	refreshToken, err := app.models.Tokens.NewRefresh(user.ID, app.config.auth.refreshTTL, family, r.UserAgent())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.Claims{
+		Issuer:       "greenlight",
+		Subject:      strconv.FormatInt(user.ID, 10),
+		IssuedAt:     now.Unix(),
+		Expiry:       now.Add(app.config.auth.accessTTL).Unix(),
+		Name:         user.Name,
+		Email:        user.Email,
+		Role:         user.Role,
+		Organization: user.OrganizationID,
+		Activated:    user.Activated,
+		Permissions:  permissions,
+		Session:      hex.EncodeToString(refreshToken.Family),
+	}
+
+	accessToken, err := jwt.Sign(claims, []byte(app.config.auth.jwtSecret))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"access_token": map[string]any{
+			"token":  accessToken,
+			"expiry": time.Unix(claims.Expiry, 0),
+		},
+		"refresh_token": refreshToken,
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createRefreshedTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data2.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Each refresh token can only be exchanged once. The replacement belongs to
+	// the same family, so a stolen token that is replayed later takes the whole
+	// login down with it.
+	token, err := app.models.Tokens.UseRefreshToken(input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data2.ErrRefreshTokenReused):
+			app.logger.PrintInfo("refresh token reused, token family revoked", map[string]string{
+				"ip": r.RemoteAddr,
+			})
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data2.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(token.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data2.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if app.refuseDisabledLogin(w, r, user) {
+		return
+	}
+
+	app.sendAccessToken(w, r, user, token.Family)
+}
+
+func (app *application) deleteRefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data2.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Tokens.DeleteRefreshFamily(input.RefreshToken)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.config.auth.cookies {
+		app.clearSessionCookies(w)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been signed out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"encoding/hex"
 	"errors"
+	"fmt"
 
 	"net/http"
 	"time"
 
 	"github.com/laldil/greenlight/internal/data"
 	"github.com/laldil/greenlight/internal/validator"
+	"github.com/laldil/greenlight/internal/webauthn"
 )
 
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -16,7 +19,6 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
-		Role     string `json:"role"`
 	}
 	// Parse the request body into the anonymous struct.
 	err := app.readJSON(w, r, &input)
@@ -28,11 +30,14 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	// set the Activated field to false, which isn't strictly necessary because the
 	// Activated field will have the zero-value of false by default. But setting this
 	// explicitly helps to make our intentions clear to anyone reading the code.
+	// Public sign-ups are always customers; staff accounts are created through
+	// invitations instead. Customers belong to the restaurant they signed up at.
 	user := &data.User{
-		Name:      input.Name,
-		Email:     input.Email,
-		Activated: false,
-		Role:      input.Role,
+		Name:           input.Name,
+		Email:          input.Email,
+		Activated:      false,
+		Role:           data.RoleUser,
+		OrganizationID: app.contextGetTenant(r).ID,
 	}
 	// Use the Password.Set() method to generate and store the hashed and plaintext
 	// passwords.
@@ -66,6 +71,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	app.auditUser(r, data.AuditRegistration, data.AuditSuccess, user, "")
+
 	// After the user record has been created in the database, generate a new activation
 	// token for the user.
 	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
@@ -83,26 +90,19 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	//}
 
 	// Launch a goroutine which runs an anonymous function that sends the welcome email.
+	// As there are now multiple pieces of data that we want to pass to our email
+	// templates, we create a map to act as a 'holding structure' for the data. This
+	// contains the plaintext version of the activation token for the user, along
+	// with their ID.
 	app.background(func() {
-		// As there are now multiple pieces of data that we want to pass to our email
-		// templates, we create a map to act as a 'holding structure' for the data. This
-		// contains the plaintext version of the activation token for the user, along
-		// with their ID.
-		app.background(func() {
-			data := map[string]any{
-				"activationToken": token.Plaintext,
-				"userID":          user.ID,
-			}
-
-			err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
-			if err != nil {
-				app.logger.PrintError(err, nil)
-			}
-		})
+		data := map[string]any{
+			"activationToken": token.Plaintext,
+			"userID":          user.ID,
+		}
 
-		err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+		err := app.mailer.Send(user.Email, "user_welcome.tmpl", data)
 		if err != nil {
-			app.serverErrorResponse(w, r, err)
+			app.logger.PrintError(err, nil)
 		}
 	})
 
@@ -142,6 +142,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			app.audit(r, &data.AuditEvent{Action: data.AuditActivation, Outcome: data.AuditFailure, Detail: "invalid token"})
 			v.AddError("token", "invalid or expired activation token")
 			app.failedValidationResponse(w, r, v.Errors)
 		default:
@@ -149,13 +150,35 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
+	// Confirming the address doesn't undo an admin disabling the account.
+	if user.Disabled {
+		app.auditUser(r, data.AuditActivation, data.AuditFailure, user, "disabled")
+		app.accountDisabledResponse(w, r)
+		return
+	}
 	// Update the user's activation status.
 	user.Activated = true
 
-	err = user.Password.Set(input.NewPassword)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+	// A new password can be chosen while activating. Without one, the password
+	// given at registration is kept.
+	if input.NewPassword != "" {
+		data.ValidatePasswordPlaintext(v, input.NewPassword)
+		data.ValidatePasswordStrength(v, input.NewPassword)
+		err = app.checkPasswordReuse(v, user, input.NewPassword)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = user.Password.Set(input.NewPassword)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 	}
 	// Save the updated user record in our database, checking for any edit conflicts in
 	// the same way that we did for our movie records.
@@ -169,6 +192,11 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
+	err = app.models.Passwords.Insert(user, app.config.password.history)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	// If everything went successfully, then we delete all activation tokens for the
 	// user.
 	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
@@ -176,9 +204,821 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+
+	app.auditUser(r, data.AuditActivation, data.AuditSuccess, user, "")
+
 	// Send the updated user details to the client in a JSON response.
 	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse and validate the user's new password and password reset token.
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidatePasswordStrength(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Retrieve the details of the user associated with the password reset token,
+	// returning an error message if no matching record was found.
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.Disabled {
+		app.accountDisabledResponse(w, r)
+		return
+	}
+
+	err = app.checkPasswordReuse(v, user, input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Set the new password for the user.
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Passwords.Insert(user, app.config.password.history)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// If everything was successful, then delete all password reset tokens for the
+	// user, and sign them out everywhere by deleting their authentication tokens too.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Tokens.DeleteSessionsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "your password was successfully reset"}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) registerInvitedUserHandler(w http.ResponseWriter, r *http.Request) {
+	// The invitee picks their own name and password; the email address and role
+	// were fixed when the invitation was sent.
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Name           string `json:"name"`
+		Password       string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeInvitation, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired invitation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.Disabled {
+		app.auditUser(r, data.AuditActivation, data.AuditFailure, user, "disabled")
+		app.accountDisabledResponse(w, r)
+		return
+	}
+
+	user.Name = input.Name
+	// The invitation was delivered to this address, so there is no need for a
+	// separate activation step.
+	user.Activated = true
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Invitations are single-use.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeInvitation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditUser(r, data.AuditActivation, data.AuditSuccess, user, "invitation accepted")
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name  string
+		Email string
+		Role  string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.Email = app.readString(qs, "email", "")
+	input.Role = app.readString(qs, "role", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "name", "email", "role", "created_at", "-id", "-name", "-email", "-role", "-created_at"}
+
+	if input.Role != "" {
+		data.ValidateRole(v, input.Role)
+	}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(app.contextGetTenant(r).ID, input.Name, input.Email, input.Role, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetForOrganization(id, app.contextGetTenant(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Whether the user has confirmed their email address is up to them, so
+	// admins turn accounts off and on with disabled rather than activated.
+	var input struct {
+		Role     *string `json:"role"`
+		Disabled *bool   `json:"disabled"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	oldRole := user.Role
+	roleChanged := input.Role != nil && *input.Role != user.Role
+	disabledChanged := input.Disabled != nil && *input.Disabled != user.Disabled
+	if input.Role != nil {
+		user.Role = *input.Role
+	}
+
+	if input.Disabled != nil {
+		user.Disabled = *input.Disabled
+	}
+
+	v := validator.New()
+
+	if data.ValidateRole(v, user.Role); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// A new role replaces whatever the old one granted.
+	var permissions data.Permissions
+	if roleChanged {
+		permissions = data.PermissionsForRole(user.Role)
+	}
+
+	err = app.models.Users.UpdateAccess(user, permissions)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrLastAdmin):
+			app.lastAdminResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if roleChanged {
+		app.audit(r, &data.AuditEvent{
+			Action:   data.AuditRoleChange,
+			Outcome:  data.AuditSuccess,
+			TargetID: &user.ID,
+			Detail:   oldRole + " -> " + user.Role,
+		})
+	}
+
+	if disabledChanged {
+		action := data.AuditReactivation
+		if user.Disabled {
+			action = data.AuditDeactivation
+		}
+
+		app.audit(r, &data.AuditEvent{
+			Action:   action,
+			Outcome:  data.AuditSuccess,
+			TargetID: &user.ID,
+			Detail:   "by admin",
+		})
+	}
+
+	// Disabled users are signed out everywhere.
+	if user.Disabled {
+		err = app.models.Tokens.DeleteSessionsForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetForOrganization(id, app.contextGetTenant(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Users are disabled rather than deleted, so that their account can be
+	// restored by sending {"disabled": false} to PATCH /v1/users/:id.
+	user.Disabled = true
+
+	err = app.models.Users.UpdateAccess(user, nil)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrLastAdmin):
+			app.lastAdminResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteSessionsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, &data.AuditEvent{
+		Action:   data.AuditDeactivation,
+		Outcome:  data.AuditSuccess,
+		TargetID: &user.ID,
+		Detail:   "by admin",
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deactivated"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Name            *string `json:"name"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	// Changing the password or email address can lock the real owner out of the
+	// account, so both need the current password as well as a valid token.
+	if input.Password != nil || input.Email != nil {
+		match, err := user.Password.Matches(input.CurrentPassword)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !match {
+			v.AddError("current_password", "is incorrect")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	if input.Password != nil {
+		err = app.checkPasswordReuse(v, user, *input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = user.Password.Set(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// A new email address only takes effect once it has been confirmed, so for
+	// now it's just remembered as pending.
+	emailChanged := input.Email != nil && *input.Email != user.Email
+	if emailChanged {
+		user.PendingEmail = *input.Email
+		data.ValidateEmail(v, user.PendingEmail)
+	}
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if emailChanged {
+		_, err = app.models.Users.GetByEmail(user.PendingEmail)
+		switch {
+		case err == nil:
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		case !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if input.Password != nil {
+		err = app.models.Passwords.Insert(user, app.config.password.history)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if emailChanged {
+		// Only the most recent change request can be confirmed.
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeEmailChange)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]any{
+				"emailChangeToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.PendingEmail, "user_email_change.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.PendingEmail == "" {
+		v.AddError("token", "invalid or expired email change token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user.Email = user.PendingEmail
+	user.PendingEmail = ""
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		// Someone else may have taken the address since the change was requested.
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkPasswordReuse adds a validation error if plaintext is the user's current
+// password or one of the previous ones they aren't allowed to reuse yet. It
+// must be called before the new password is set.
+func (app *application) checkPasswordReuse(v *validator.Validator, user *data.User, plaintext string) error {
+	reused, err := app.models.Passwords.Reused(user, plaintext, app.config.password.history)
+	if err != nil {
+		return err
+	}
+
+	v.Check(!reused, "password", "must not be a password you have used recently")
+	return nil
+}
+
+// exportCurrentUserHandler returns everything we hold about the current user,
+// so that they can take a copy of it.
+func (app *application) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(user.ID, app.contextGetToken(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	apiKeys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	passkeys, err := app.models.Passkeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	totp, err := app.models.TOTP.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	contacts, err := app.models.Contacts.GetAllForEmail(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"export": map[string]any{
+		"exported_at":        time.Now(),
+		"user":               user,
+		"permissions":        permissions,
+		"sessions":           sessions,
+		"api_keys":           apiKeys,
+		"passkeys":           passkeys,
+		"two_factor_enabled": totp != nil && totp.Enabled,
+		"contact_messages":   contacts,
+	}}
+
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", `attachment; filename="greenlight-export.json"`)
+
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reauthenticationWindow is how long after logging in users may do things that
+// would otherwise need their password again.
+const reauthenticationWindow = 5 * time.Minute
+
+// reauthenticateWithPassword checks the user's password, and their second
+// factor if they have one.
+func (app *application) reauthenticateWithPassword(user *data.User, password, code, recoveryCode string) (bool, error) {
+	match, err := user.Password.Matches(password)
+	if err != nil || !match {
+		return false, err
+	}
+
+	totp, err := app.models.TOTP.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return false, err
+	}
+	if totp != nil && totp.Enabled {
+		return app.checkSecondFactor(user.ID, code, recoveryCode)
+	}
+
+	return true, nil
+}
+
+// reauthenticateWithPasskey checks a passkey assertion over a challenge that
+// the user got from POST /v1/tokens/passkey.
+func (app *application) reauthenticateWithPasskey(user *data.User, assertion passkeyAssertion) (bool, error) {
+	clientData, err := webauthn.ParseClientData(assertion.ClientDataJSON)
+	if err != nil {
+		return false, nil
+	}
+
+	challengeUser, err := app.models.Users.GetForToken(data.ScopePasskeyLogin, string(clientData.Challenge))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	if challengeUser.ID != user.ID {
+		return false, nil
+	}
+
+	return app.verifyPasskeyAssertion(user, clientData, assertion)
+}
+
+// recentlyLoggedIn reports whether the login that the request was made with
+// started within the reauthenticationWindow, so that the user has only just
+// proven who they are.
+func (app *application) recentlyLoggedIn(r *http.Request) (bool, error) {
+	var startedAt time.Time
+	var err error
+
+	if claims := app.contextGetClaims(r); claims != nil {
+		family, decodeErr := hex.DecodeString(claims.Session)
+		if decodeErr != nil || len(family) == 0 {
+			return false, nil
+		}
+		startedAt, err = app.models.Tokens.RefreshFamilyStartedAt(family)
+	} else {
+		startedAt, err = app.models.Tokens.SessionStartedAt(app.contextGetToken(r))
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return time.Since(startedAt) < reauthenticationWindow, nil
+}
+
+// deleteCurrentUserHandler lets users delete their own account. Unlike
+// deleteUserHandler, which only deactivates, this removes the account and its
+// contact messages for good. Audit events are kept, but without the address.
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Like creating API keys, this can only be done from a real user session.
+	if app.contextGetAPIKey(r) != nil {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Password     string            `json:"password"`
+		Code         string            `json:"code"`
+		RecoveryCode string            `json:"recovery_code"`
+		Passkey      *passkeyAssertion `json:"passkey"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// A stolen session isn't enough to delete an account, so the user has to
+	// prove who they are again. Accounts without a password of their own, such
+	// as ones made by a single sign-on login, can do it with a passkey or by
+	// logging in again just before.
+	var valid bool
+	switch {
+	case input.Passkey != nil:
+		valid, err = app.reauthenticateWithPasskey(user, *input.Passkey)
+	case input.Password != "":
+		valid, err = app.reauthenticateWithPassword(user, input.Password, input.Code, input.RecoveryCode)
+	default:
+		valid, err = app.recentlyLoggedIn(r)
+		if err == nil && !valid {
+			v := validator.New()
+			v.AddError("password", fmt.Sprintf("must be provided, unless a passkey is used or you logged in within the last %d minutes", int(reauthenticationWindow.Minutes())))
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !valid {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.models.Users.Delete(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrLastAdmin):
+			app.lastAdminResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, &data.AuditEvent{
+		Action:  data.AuditDeletion,
+		Outcome: data.AuditSuccess,
+		ActorID: &user.ID,
+	})
+
+	if app.config.auth.cookies {
+		app.clearSessionCookies(w)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account has been deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
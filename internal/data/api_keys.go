@@ -0,0 +1,224 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/laldil/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix starts every API key, which makes keys easy to tell apart from
+// authentication tokens and to spot if they are leaked.
+const APIKeyPrefix = "glk_"
+
+// APIKey is a long-lived credential for machine clients such as POS terminals.
+// A key acts as the user who created it, but only with the permissions listed
+// on the key.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Plaintext   string      `json:"key,omitempty"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	Expiry      *time.Time  `json:"expiry"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+func generateAPIKey(apiKey *APIKey) error {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	apiKey.Plaintext = APIKeyPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+
+	hash := sha256.Sum256([]byte(apiKey.Plaintext))
+	apiKey.Hash = hash[:]
+
+	return nil
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, keyPlaintext string) {
+	v.Check(strings.HasPrefix(keyPlaintext, APIKeyPrefix), "key", "must be a valid API key")
+	v.Check(len(keyPlaintext) == len(APIKeyPrefix)+32, "key", "must be a valid API key")
+}
+
+func ValidateAPIKey(v *validator.Validator, apiKey *APIKey) {
+	v.Check(apiKey.Name != "", "name", "must be provided")
+	v.Check(len(apiKey.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(validator.Unique(apiKey.Permissions), "permissions", "must not contain duplicate values")
+	if apiKey.Expiry != nil {
+		v.Check(apiKey.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+// Insert generates the key itself and stores its hash. The plaintext is only
+// available on the returned struct, so it must be shown to the user now.
+func (m APIKeyModel) Insert(apiKey *APIKey) error {
+	err := generateAPIKey(apiKey)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO api_keys (user_id, name, hash, permissions, expiry)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	args := []any{apiKey.UserID, apiKey.Name, apiKey.Hash, pq.Array([]string(apiKey.Permissions)), apiKey.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&apiKey.ID, &apiKey.CreatedAt)
+}
+
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, created_at, user_id, name, permissions, expiry, last_used_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apiKeys := []*APIKey{}
+	for rows.Next() {
+		var apiKey APIKey
+		err := rows.Scan(
+			&apiKey.ID,
+			&apiKey.CreatedAt,
+			&apiKey.UserID,
+			&apiKey.Name,
+			pq.Array((*[]string)(&apiKey.Permissions)),
+			&apiKey.Expiry,
+			&apiKey.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		apiKeys = append(apiKeys, &apiKey)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return apiKeys, nil
+}
+
+// GetForKey looks up an unexpired API key together with the user it belongs to.
+func (m APIKeyModel) GetForKey(keyPlaintext string) (*User, *APIKey, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.disabled, users.version, users.role, users.pending_email, users.organization_id,
+		       api_keys.id, api_keys.created_at, api_keys.user_id, api_keys.name, api_keys.permissions, api_keys.expiry, api_keys.last_used_at
+		FROM api_keys
+		INNER JOIN users ON users.id = api_keys.user_id
+		WHERE api_keys.hash = $1
+		AND (api_keys.expiry IS NULL OR api_keys.expiry > $2)`
+
+	var user User
+	var apiKey APIKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, keyHash[:], time.Now()).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Disabled,
+		&user.Version,
+		&user.Role,
+		&user.PendingEmail,
+		&user.OrganizationID,
+		&apiKey.ID,
+		&apiKey.CreatedAt,
+		&apiKey.UserID,
+		&apiKey.Name,
+		pq.Array((*[]string)(&apiKey.Permissions)),
+		&apiKey.Expiry,
+		&apiKey.LastUsedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return &user, &apiKey, nil
+}
+
+// UpdateLastUsed records that a key has just been used, at most once a minute.
+func (m APIKeyModel) UpdateLastUsed(id int64) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1
+		AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// DeleteForUser revokes a key, as long as it belongs to the given user.
+func (m APIKeyModel) DeleteForUser(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,276 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/laldil/greenlight/internal/validator"
+)
+
+var (
+	// ErrUnknownCategory is returned when a food is linked to, or a category
+	// is moved under, a category that doesn't exist in its organization.
+	ErrUnknownCategory = errors.New("unknown category")
+
+	// ErrCategoryLoop is returned when a category would be moved under itself
+	// or one of its own subcategories.
+	ErrCategoryLoop = errors.New("category loop")
+)
+
+// Category is a section of the menu, like "Starters" or "Desserts". Categories
+// can be nested with ParentID, and foods can be in any number of them.
+type Category struct {
+	ID             int64       `json:"id"`
+	CreatedAt      time.Time   `json:"-"`
+	OrganizationID int64       `json:"-"`
+	ParentID       *int64      `json:"parent_id"`
+	Name           string      `json:"name"`
+	SortOrder      int32       `json:"sort_order"`
+	Version        int32       `json:"version"`
+	Children       []*Category `json:"children,omitempty"`
+	Foods          []*Food     `json:"foods,omitempty"`
+}
+
+func ValidateCategory(v *validator.Validator, category *Category) {
+	v.Check(category.Name != "", "name", "must be provided")
+	v.Check(len(category.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(category.SortOrder >= 0, "sort_order", "must not be negative")
+
+	if category.ParentID != nil {
+		v.Check(*category.ParentID > 0, "parent_id", "must be a positive integer")
+		v.Check(*category.ParentID != category.ID, "parent_id", "must not be the category itself")
+	}
+}
+
+type CategoryModel struct {
+	DB *sql.DB
+}
+
+func (m CategoryModel) Insert(category *Category) error {
+	query := `
+		INSERT INTO categories (organization_id, parent_id, name, sort_order)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []any{category.OrganizationID, category.ParentID, category.Name, category.SortOrder}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&category.ID, &category.CreatedAt, &category.Version)
+}
+
+// Get returns a category from the given organization. Categories belonging to
+// other organizations are reported as not found.
+func (m CategoryModel) Get(orgID, id int64) (*Category, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, organization_id, parent_id, name, sort_order, version
+		FROM categories
+		WHERE id = $1 AND organization_id = $2`
+
+	var category Category
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, orgID).Scan(
+		&category.ID,
+		&category.CreatedAt,
+		&category.OrganizationID,
+		&category.ParentID,
+		&category.Name,
+		&category.SortOrder,
+		&category.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &category, nil
+}
+
+// GetAll returns every category in the organization as a flat list, in menu
+// order.
+func (m CategoryModel) GetAll(orgID int64) ([]*Category, error) {
+	query := `
+		SELECT id, created_at, organization_id, parent_id, name, sort_order, version
+		FROM categories
+		WHERE organization_id = $1
+		ORDER BY sort_order, name, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []*Category{}
+	for rows.Next() {
+		var category Category
+		err := rows.Scan(
+			&category.ID,
+			&category.CreatedAt,
+			&category.OrganizationID,
+			&category.ParentID,
+			&category.Name,
+			&category.SortOrder,
+			&category.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		categories = append(categories, &category)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+// Update saves the category. A category that is moved is checked against the
+// organization's other categories while they are locked, so that two moves at
+// once can't together make a loop that neither would alone. ErrUnknownCategory
+// or ErrCategoryLoop is returned if the new parent doesn't exist or is the
+// category itself or one of its subcategories.
+func (m CategoryModel) Update(category *Category) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if category.ParentID != nil {
+		_, err = tx.ExecContext(ctx, `SELECT id FROM categories WHERE organization_id = $1 FOR UPDATE`, category.OrganizationID)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			WITH RECURSIVE ancestors AS (
+				SELECT id, parent_id FROM categories WHERE id = $1 AND organization_id = $3
+				UNION
+				SELECT categories.id, categories.parent_id
+				FROM categories
+				INNER JOIN ancestors ON categories.id = ancestors.parent_id
+			)
+			SELECT EXISTS (SELECT 1 FROM ancestors), EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`
+
+		var exists, within bool
+		err = tx.QueryRowContext(ctx, query, *category.ParentID, category.ID, category.OrganizationID).Scan(&exists, &within)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case !exists:
+			return ErrUnknownCategory
+		case within:
+			return ErrCategoryLoop
+		}
+	}
+
+	query := `
+		UPDATE categories
+		SET parent_id = $1, name = $2, sort_order = $3, version = version + 1
+		WHERE id = $4 AND organization_id = $5 AND version = $6
+		RETURNING version`
+
+	args := []any{
+		category.ParentID,
+		category.Name,
+		category.SortOrder,
+		category.ID,
+		category.OrganizationID,
+		category.Version,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&category.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a category along with all of its subcategories. Foods in them
+// are kept, they just stop being linked to the deleted categories.
+func (m CategoryModel) Delete(orgID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `DELETE FROM categories WHERE id = $1 AND organization_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, orgID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// CategoryTree nests categories under their parents and puts each food into
+// every category it's linked to. The order of the given categories and foods is
+// kept. The top-level categories are returned.
+func CategoryTree(categories []*Category, foods []*Food) []*Category {
+	byID := make(map[int64]*Category, len(categories))
+	for _, category := range categories {
+		byID[category.ID] = category
+	}
+
+	for _, food := range foods {
+		for _, id := range food.Categories {
+			if category, ok := byID[id]; ok {
+				category.Foods = append(category.Foods, food)
+			}
+		}
+	}
+
+	roots := []*Category{}
+	for _, category := range categories {
+		if category.ParentID != nil {
+			if parent, ok := byID[*category.ParentID]; ok {
+				parent.Children = append(parent.Children, category)
+				continue
+			}
+		}
+
+		roots = append(roots, category)
+	}
+
+	return roots
+}
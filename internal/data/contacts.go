@@ -0,0 +1,65 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Contact is a message sent through the contact form. Messages aren't linked to
+// user accounts, only to the email address they were sent from.
+type Contact struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Subject   string    `json:"subject"`
+	Message   string    `json:"message"`
+	Version   int32     `json:"-"`
+}
+
+type ContactModel struct {
+	DB *sql.DB
+}
+
+func (m ContactModel) GetAllForEmail(email string) ([]*Contact, error) {
+	query := `
+		SELECT id, created_at, name, email, subject, message, version
+		FROM contacts
+		WHERE LOWER(email) = LOWER($1)
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []*Contact{}
+	for rows.Next() {
+		var contact Contact
+		err := rows.Scan(
+			&contact.ID,
+			&contact.CreatedAt,
+			&contact.Name,
+			&contact.Email,
+			&contact.Subject,
+			&contact.Message,
+			&contact.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		contacts = append(contacts, &contact)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
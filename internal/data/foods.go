@@ -12,49 +12,228 @@ import (
 )
 
 type Food struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Price     int32     `json:"price"`
-	Waittime  int32     `json:"waittime"`
-	Recipe    []string  `json:"recipe"`
-	Version   int32     `json:"version"`
+	ID             int64      `json:"id"`
+	CreatedAt      time.Time  `json:"-"`
+	OrganizationID int64      `json:"-"`
+	Title          string     `json:"title"`
+	Price          int32      `json:"price"`
+	Waittime       int32      `json:"waittime"`
+	Available      bool       `json:"available"`
+	Schedule       Schedule   `json:"schedule"`
+	Ingredients    []int64    `json:"ingredients"`
+	Recipe         []string   `json:"recipe"`
+	Allergens      []string   `json:"allergens"`
+	Diets          []string   `json:"diets"`
+	Categories     []int64    `json:"categories"`
+	Images         FoodImages `json:"images"`
+	CreatedBy      *int64     `json:"created_by"`
+	UpdatedBy      *int64     `json:"updated_by"`
+	Version        int32      `json:"version"`
 }
 
+// foodIngredientColumns work out a food's ingredient IDs, its recipe (the names
+// of those ingredients), and its allergens and diets. A food has every allergen
+// of each of its ingredients, but only the diets that all of them share. Its
+// allergens or diets are NULL if any of its ingredients' are, since they can't
+// be known until every ingredient has been classified.
+const foodIngredientColumns = `
+	ARRAY(
+		SELECT ingredients.id
+		FROM foods_ingredients
+		INNER JOIN ingredients ON ingredients.id = foods_ingredients.ingredient_id
+		WHERE foods_ingredients.food_id = foods.id
+		ORDER BY ingredients.name),
+	ARRAY(
+		SELECT ingredients.name::text
+		FROM foods_ingredients
+		INNER JOIN ingredients ON ingredients.id = foods_ingredients.ingredient_id
+		WHERE foods_ingredients.food_id = foods.id
+		ORDER BY ingredients.name),
+	CASE WHEN EXISTS (
+		SELECT 1
+		FROM foods_ingredients
+		INNER JOIN ingredients ON ingredients.id = foods_ingredients.ingredient_id
+		WHERE foods_ingredients.food_id = foods.id AND ingredients.allergens IS NULL)
+	THEN NULL ELSE ARRAY(
+		SELECT DISTINCT allergen
+		FROM foods_ingredients
+		INNER JOIN ingredients ON ingredients.id = foods_ingredients.ingredient_id
+		CROSS JOIN LATERAL unnest(ingredients.allergens) AS allergen
+		WHERE foods_ingredients.food_id = foods.id
+		ORDER BY allergen) END,
+	CASE WHEN EXISTS (
+		SELECT 1
+		FROM foods_ingredients
+		INNER JOIN ingredients ON ingredients.id = foods_ingredients.ingredient_id
+		WHERE foods_ingredients.food_id = foods.id AND ingredients.diets IS NULL)
+	THEN NULL ELSE ARRAY(
+		SELECT diet
+		FROM foods_ingredients
+		INNER JOIN ingredients ON ingredients.id = foods_ingredients.ingredient_id
+		CROSS JOIN LATERAL unnest(ingredients.diets) AS diet
+		WHERE foods_ingredients.food_id = foods.id
+		GROUP BY diet
+		HAVING COUNT(*) = (SELECT COUNT(*) FROM foods_ingredients AS linked WHERE linked.food_id = foods.id)
+		ORDER BY diet) END`
+
+const foodColumns = `
+	foods.id, foods.created_at, foods.organization_id, foods.title, foods.price, foods.waittime, foods.available,
+	COALESCE((
+		SELECT json_agg(json_build_object('days', days, 'starts_at', starts_at, 'ends_at', ends_at) ORDER BY starts_at, id)
+		FROM food_schedules
+		WHERE food_id = foods.id), '[]'),` + foodIngredientColumns + `,
+	ARRAY(SELECT category_id FROM foods_categories WHERE food_id = foods.id ORDER BY category_id),
+	ARRAY(SELECT id FROM food_images WHERE food_id = foods.id ORDER BY id),
+	foods.created_by, foods.updated_by, foods.version`
+
 type FoodModel struct {
 	DB *sql.DB
 }
 
+// Insert adds the food, with its schedule, and links it to its ingredients and
+// categories. ErrUnknownIngredient or ErrUnknownCategory is returned, and
+// nothing is saved, if any of them doesn't exist in the food's organization.
 func (f FoodModel) Insert(food *Food) error {
 	query :=
-		`INSERT INTO foods (title, price, waittime, recipe)
-		 VALUES ($1, $2, $3, $4)
+		`INSERT INTO foods (organization_id, title, price, waittime, available, created_by, updated_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
 		 RETURNING id, created_at, version`
-	args := []any{food.Title, food.Price, food.Waittime, pq.Array(food.Recipe)}
+	args := []any{food.OrganizationID, food.Title, food.Price, food.Waittime, food.Available, food.CreatedBy, food.UpdatedBy}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return f.DB.QueryRowContext(ctx, query, args...).Scan(&food.ID, &food.CreatedAt, &food.Version)
+	tx, err := f.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&food.ID, &food.CreatedAt, &food.Version)
+	if err != nil {
+		return err
+	}
+
+	err = setFoodSchedule(ctx, tx, food)
+	if err != nil {
+		return err
+	}
+
+	err = setFoodIngredients(ctx, tx, food)
+	if err != nil {
+		return err
+	}
+
+	err = setFoodCategories(ctx, tx, food)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setFoodIngredients replaces the ingredients the food is made of, then reads
+// back the recipe, allergens and diets that follow from them.
+func setFoodIngredients(ctx context.Context, tx *sql.Tx, food *Food) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM foods_ingredients WHERE food_id = $1`, food.ID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO foods_ingredients (food_id, ingredient_id)
+		SELECT $1, id FROM ingredients
+		WHERE id = ANY($2) AND organization_id = $3`
+
+	result, err := tx.ExecContext(ctx, query, food.ID, pq.Array(food.Ingredients), food.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(food.Ingredients)) {
+		return ErrUnknownIngredient
+	}
+
+	query = `SELECT ` + foodIngredientColumns + ` FROM foods WHERE id = $1`
+
+	return tx.QueryRowContext(ctx, query, food.ID).Scan(
+		pq.Array(&food.Ingredients),
+		pq.Array(&food.Recipe),
+		pq.Array(&food.Allergens),
+		pq.Array(&food.Diets),
+	)
+}
+
+// setFoodCategories replaces the categories the food is linked to.
+func setFoodCategories(ctx context.Context, tx *sql.Tx, food *Food) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM foods_categories WHERE food_id = $1`, food.ID)
+	if err != nil {
+		return err
+	}
+
+	if food.Categories == nil {
+		food.Categories = []int64{}
+	}
+
+	query := `
+		INSERT INTO foods_categories (food_id, category_id)
+		SELECT $1, id FROM categories
+		WHERE id = ANY($2) AND organization_id = $3`
+
+	result, err := tx.ExecContext(ctx, query, food.ID, pq.Array(food.Categories), food.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(food.Categories)) {
+		return ErrUnknownCategory
+	}
+
+	return nil
 }
 
-func (f FoodModel) Get(id int64) (*Food, error) {
+// Get returns a food from the given organization. Foods belonging to other
+// organizations are reported as not found.
+func (f FoodModel) Get(orgID, id int64) (*Food, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `SELECT * FROM foods WHERE id = $1`
+	query := `
+		SELECT ` + foodColumns + `
+		FROM foods
+		WHERE id = $1 AND organization_id = $2`
 
 	var food Food
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := f.DB.QueryRowContext(ctx, query, id).Scan(
+	err := f.DB.QueryRowContext(ctx, query, id, orgID).Scan(
 		&food.ID,
 		&food.CreatedAt,
+		&food.OrganizationID,
 		&food.Title,
 		&food.Price,
 		&food.Waittime,
+		&food.Available,
+		&food.Schedule,
+		pq.Array(&food.Ingredients),
 		pq.Array(&food.Recipe),
+		pq.Array(&food.Allergens),
+		pq.Array(&food.Diets),
+		pq.Array(&food.Categories),
+		pq.Array((*[]int64)(&food.Images)),
+		&food.CreatedBy,
+		&food.UpdatedBy,
 		&food.Version,
 	)
 
@@ -70,19 +249,70 @@ func (f FoodModel) Get(id int64) (*Food, error) {
 	return &food, nil
 }
 
-func (f FoodModel) GetAll(title string, recipe []string, filters Filters) ([]*Food, Metadata, error) {
+// GetAll returns a page of the organization's foods. Foods are only included if
+// their recipe has every ingredient named in recipe, none of the excluded
+// allergens, and suits all of the given diets. When filtering on allergens or
+// diets, foods with an ingredient that hasn't been classified yet are left out,
+// as it isn't known whether they match. A non-zero categoryID limits the
+// results to foods in that category or any category nested below it, and a
+// non-zero availableAt to foods that can be ordered at that time, which should
+// be in the restaurant's time zone. A schedule window that ends before it starts
+// runs overnight, into the early hours of the day after each of its days.
+func (f FoodModel) GetAll(orgID int64, title string, recipe []string, excludeAllergens []string, diets []string, categoryID int64, availableAt time.Time, filters Filters) ([]*Food, Metadata, error) {
 	query := fmt.Sprintf(` 
-		SELECT COUNT(*) OVER(), id, created_at, title, price, waittime, recipe, version
+		SELECT COUNT(*) OVER(), %s
 		FROM foods 
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND (recipe @> $2 OR $2 = '{}')
-		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+		WHERE foods.organization_id = $1
+		AND (to_tsvector('simple', foods.title) @@ plainto_tsquery('simple', $2) OR $2 = '')
+		AND NOT EXISTS (
+			SELECT 1 FROM unnest($3::text[]) AS wanted(name)
+			WHERE NOT EXISTS (
+				SELECT 1
+				FROM foods_ingredients
+				INNER JOIN ingredients ON ingredients.id = foods_ingredients.ingredient_id
+				WHERE foods_ingredients.food_id = foods.id AND ingredients.name = wanted.name::citext))
+		AND NOT EXISTS (
+			SELECT 1
+			FROM foods_ingredients
+			INNER JOIN ingredients ON ingredients.id = foods_ingredients.ingredient_id
+			WHERE foods_ingredients.food_id = foods.id
+			AND (ingredients.allergens && $4::text[] OR NOT ingredients.diets @> $5::text[]
+				OR (cardinality($4::text[]) > 0 AND ingredients.allergens IS NULL)
+				OR (cardinality($5::text[]) > 0 AND ingredients.diets IS NULL)))
+		AND ($6 = 0 OR foods.id IN (
+			WITH RECURSIVE tree AS (
+				SELECT id FROM categories WHERE id = $6
+				UNION
+				SELECT categories.id FROM categories INNER JOIN tree ON categories.parent_id = tree.id
+			)
+			SELECT food_id FROM foods_categories WHERE category_id IN (SELECT id FROM tree)))
+		AND (NOT $7 OR (foods.available AND (
+			NOT EXISTS (SELECT 1 FROM food_schedules WHERE food_id = foods.id)
+			OR EXISTS (
+				SELECT 1 FROM food_schedules
+				WHERE food_id = foods.id AND (
+					($8 = ANY(days) AND starts_at <= $9 AND ($9 < ends_at OR ends_at < starts_at))
+					OR ($10 = ANY(days) AND ends_at < starts_at AND $9 < ends_at))))))
+		ORDER BY %s %s, foods.id ASC
+		LIMIT $11 OFFSET $12`, foodColumns, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{title, pq.Array(recipe), filters.limit(), filters.offset()}
+	args := []any{
+		orgID,
+		title,
+		pq.Array(recipe),
+		pq.Array(excludeAllergens),
+		pq.Array(diets),
+		categoryID,
+		!availableAt.IsZero(),
+		int(availableAt.Weekday()),
+		availableAt.Hour()*60 + availableAt.Minute(),
+		(int(availableAt.Weekday()) + 6) % 7,
+		filters.limit(),
+		filters.offset(),
+	}
 
 	rows, err := f.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -99,10 +329,20 @@ func (f FoodModel) GetAll(title string, recipe []string, filters Filters) ([]*Fo
 			&totalRecords,
 			&food.ID,
 			&food.CreatedAt,
+			&food.OrganizationID,
 			&food.Title,
 			&food.Price,
 			&food.Waittime,
+			&food.Available,
+			&food.Schedule,
+			pq.Array(&food.Ingredients),
 			pq.Array(&food.Recipe),
+			pq.Array(&food.Allergens),
+			pq.Array(&food.Diets),
+			pq.Array(&food.Categories),
+			pq.Array((*[]int64)(&food.Images)),
+			&food.CreatedBy,
+			&food.UpdatedBy,
 			&food.Version,
 		)
 		if err != nil {
@@ -120,26 +360,91 @@ func (f FoodModel) GetAll(title string, recipe []string, filters Filters) ([]*Fo
 	return foods, metadata, nil
 }
 
+// GetAllForMenu returns every food in the organization that is in at least one
+// category, ordered by title.
+func (f FoodModel) GetAllForMenu(orgID int64) ([]*Food, error) {
+	query := `
+		SELECT ` + foodColumns + `
+		FROM foods
+		WHERE foods.organization_id = $1
+		AND EXISTS (SELECT 1 FROM foods_categories WHERE food_id = foods.id)
+		ORDER BY foods.title, foods.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := f.DB.QueryContext(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	foods := []*Food{}
+	for rows.Next() {
+		var food Food
+		err := rows.Scan(
+			&food.ID,
+			&food.CreatedAt,
+			&food.OrganizationID,
+			&food.Title,
+			&food.Price,
+			&food.Waittime,
+			&food.Available,
+			&food.Schedule,
+			pq.Array(&food.Ingredients),
+			pq.Array(&food.Recipe),
+			pq.Array(&food.Allergens),
+			pq.Array(&food.Diets),
+			pq.Array(&food.Categories),
+			pq.Array((*[]int64)(&food.Images)),
+			&food.CreatedBy,
+			&food.UpdatedBy,
+			&food.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		foods = append(foods, &food)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return foods, nil
+}
+
+// Update saves the food and its schedule, and replaces the ingredients and
+// categories it's linked to. As with Insert, ErrUnknownIngredient or
+// ErrUnknownCategory is returned if any of them don't exist.
 func (f FoodModel) Update(food *Food) error {
 	query :=
 		`UPDATE foods
-		 SET title = $1, price = $2, waittime = $3, recipe = $4, version = version + 1
-		 WHERE id = $5 AND version = $6
+		 SET title = $1, price = $2, waittime = $3, available = $4, updated_by = $5, version = version + 1
+		 WHERE id = $6 AND organization_id = $7 AND version = $8
 		 RETURNING version`
 
 	args := []any{
 		food.Title,
 		food.Price,
 		food.Waittime,
-		pq.Array(food.Recipe),
+		food.Available,
+		food.UpdatedBy,
 		food.ID,
+		food.OrganizationID,
 		food.Version,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := f.DB.QueryRowContext(ctx, query, args...).Scan(&food.Version)
+	tx, err := f.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&food.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -149,19 +454,60 @@ func (f FoodModel) Update(food *Food) error {
 		}
 	}
 
+	err = setFoodSchedule(ctx, tx, food)
+	if err != nil {
+		return err
+	}
+
+	err = setFoodIngredients(ctx, tx, food)
+	if err != nil {
+		return err
+	}
+
+	err = setFoodCategories(ctx, tx, food)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetAvailable marks a food as available or not, such as when it sells out,
+// without the caller needing to know its current version.
+func (f FoodModel) SetAvailable(food *Food, available bool) error {
+	query := `
+		UPDATE foods
+		SET available = $1, updated_by = $2, version = version + 1
+		WHERE id = $3 AND organization_id = $4
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := f.DB.QueryRowContext(ctx, query, available, food.UpdatedBy, food.ID, food.OrganizationID).Scan(&food.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	food.Available = available
 	return nil
 }
 
-func (m FoodModel) Delete(id int64) error {
+func (m FoodModel) Delete(orgID, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
-	query := `DELETE FROM foods WHERE id = $1`
+	query := `DELETE FROM foods WHERE id = $1 AND organization_id = $2`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id, orgID)
 	if err != nil {
 		return err
 	}
@@ -183,9 +529,12 @@ func ValidateFood(v *validator.Validator, food *Food) {
 	v.Check(len(food.Title) <= 500, "title", "must not be more than 500 bytes long")
 	v.Check(food.Price != 0, "price", "must be provided")
 	v.Check(food.Waittime != 0, "waittime", "must be provided")
-	v.Check(len(food.Recipe) > 0, "recipe", "must be provided")
+	v.Check(len(food.Ingredients) > 0, "ingredients", "must be provided")
 	v.Check(food.Waittime != 0, "waittime", "must be provided")
 	v.Check(food.Waittime > 0, "waittime", "must be a positive integer")
-	v.Check(len(food.Recipe) >= 1, "recipe", "must contain at least 1 food")
-	v.Check(validator.Unique(food.Recipe), "recipe", "must not contain duplicate values")
+	v.Check(len(food.Ingredients) >= 1, "ingredients", "must contain at least 1 ingredient")
+	v.Check(validator.Unique(food.Ingredients), "ingredients", "must not contain duplicate values")
+	v.Check(validator.Unique(food.Categories), "categories", "must not contain duplicate values")
+
+	ValidateSchedule(v, food.Schedule)
 }
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ImageOriginal is the size name for an image exactly as it was uploaded.
+const ImageOriginal = "original"
+
+// ThumbnailSizes are the scaled-down copies made of every uploaded image, by
+// name, with the longest side each one is allowed.
+var ThumbnailSizes = map[string]int{
+	"small":  160,
+	"medium": 480,
+	"large":  1024,
+}
+
+// ImageURL returns the path an image is served from at the given size.
+func ImageURL(id int64, size string) string {
+	return fmt.Sprintf("/v1/images/%d/%s", id, size)
+}
+
+// ImageURLs returns the paths of the original image and each of its
+// thumbnails, by size name.
+func ImageURLs(id int64) map[string]string {
+	urls := map[string]string{ImageOriginal: ImageURL(id, ImageOriginal)}
+	for size := range ThumbnailSizes {
+		urls[size] = ImageURL(id, size)
+	}
+	return urls
+}
+
+// FoodImage is a picture of a food. The image files themselves are stored
+// outside the database.
+type FoodImage struct {
+	ID          int64             `json:"id"`
+	CreatedAt   time.Time         `json:"-"`
+	FoodID      int64             `json:"food_id"`
+	ContentType string            `json:"content_type"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	URLs        map[string]string `json:"urls"`
+}
+
+// FoodImages holds the IDs of a food's images. In JSON each one is written out
+// with the URLs of the original and its thumbnails.
+type FoodImages []int64
+
+func (fi FoodImages) MarshalJSON() ([]byte, error) {
+	type image struct {
+		ID   int64             `json:"id"`
+		URLs map[string]string `json:"urls"`
+	}
+
+	images := make([]image, len(fi))
+	for i, id := range fi {
+		images[i] = image{ID: id, URLs: ImageURLs(id)}
+	}
+
+	return json.Marshal(images)
+}
+
+type FoodImageModel struct {
+	DB *sql.DB
+}
+
+func (m FoodImageModel) Insert(image *FoodImage) error {
+	query := `
+		INSERT INTO food_images (food_id, content_type, width, height)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, image.FoodID, image.ContentType, image.Width, image.Height).Scan(&image.ID, &image.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	image.URLs = ImageURLs(image.ID)
+	return nil
+}
+
+// Get returns an image by ID. Menu pictures are public, so unlike foods they
+// aren't limited to the organization of the request; browsers fetching them for
+// <img> tags can't send the X-Organization header.
+func (m FoodImageModel) Get(id int64) (*FoodImage, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, food_id, content_type, width, height
+		FROM food_images
+		WHERE id = $1`
+
+	var image FoodImage
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&image.ID,
+		&image.CreatedAt,
+		&image.FoodID,
+		&image.ContentType,
+		&image.Width,
+		&image.Height,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	image.URLs = ImageURLs(image.ID)
+	return &image, nil
+}
+
+func (m FoodImageModel) Delete(id int64) error {
+	query := `DELETE FROM food_images WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
@@ -0,0 +1,249 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/laldil/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateIngredient = errors.New("duplicate ingredient")
+	ErrIngredientInUse     = errors.New("ingredient in use")
+
+	// ErrUnknownIngredient is returned when a food is given an ingredient that
+	// doesn't exist in its organization.
+	ErrUnknownIngredient = errors.New("unknown ingredient")
+)
+
+// KnownAllergens are the allergens an ingredient can be flagged with.
+var KnownAllergens = []string{
+	"celery", "crustaceans", "dairy", "eggs", "fish", "gluten", "lupin",
+	"molluscs", "mustard", "nuts", "peanuts", "sesame", "soy", "sulphites",
+}
+
+// KnownDiets are the dietary tags an ingredient can have. A food only counts as
+// suitable for a diet if every one of its ingredients is.
+var KnownDiets = []string{"halal", "kosher", "vegan", "vegetarian"}
+
+// Ingredient is an entry in an organization's ingredient catalog. Names are
+// compared without regard to case, so "Tomato" and "tomato" are the same
+// ingredient. Allergens and Diets are nil until staff have classified the
+// ingredient, which isn't the same as an empty list: that means it has none.
+type Ingredient struct {
+	ID             int64     `json:"id"`
+	CreatedAt      time.Time `json:"-"`
+	OrganizationID int64     `json:"-"`
+	Name           string    `json:"name"`
+	Allergens      []string  `json:"allergens"`
+	Diets          []string  `json:"diets"`
+	Version        int32     `json:"version"`
+}
+
+func ValidateIngredient(v *validator.Validator, ingredient *Ingredient) {
+	v.Check(ingredient.Name != "", "name", "must be provided")
+	v.Check(len(ingredient.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	ValidateAllergens(v, "allergens", ingredient.Allergens)
+	ValidateDiets(v, "diets", ingredient.Diets)
+}
+
+// ValidateAllergens checks a list of allergens, reporting any problem under the
+// given key.
+func ValidateAllergens(v *validator.Validator, key string, allergens []string) {
+	for _, allergen := range allergens {
+		v.Check(validator.PermittedValue(allergen, KnownAllergens...), key, fmt.Sprintf("%q is not a known allergen", allergen))
+	}
+	v.Check(validator.Unique(allergens), key, "must not contain duplicate values")
+}
+
+// ValidateDiets checks a list of dietary tags, reporting any problem under the
+// given key.
+func ValidateDiets(v *validator.Validator, key string, diets []string) {
+	for _, diet := range diets {
+		v.Check(validator.PermittedValue(diet, KnownDiets...), key, fmt.Sprintf("%q is not a known diet", diet))
+	}
+	v.Check(validator.Unique(diets), key, "must not contain duplicate values")
+}
+
+type IngredientModel struct {
+	DB *sql.DB
+}
+
+func (m IngredientModel) Insert(ingredient *Ingredient) error {
+	query := `
+		INSERT INTO ingredients (organization_id, name, allergens, diets)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []any{ingredient.OrganizationID, ingredient.Name, pq.Array(ingredient.Allergens), pq.Array(ingredient.Diets)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&ingredient.ID, &ingredient.CreatedAt, &ingredient.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "ingredients_organization_id_name_key"`:
+			return ErrDuplicateIngredient
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get returns an ingredient from the given organization. Ingredients belonging
+// to other organizations are reported as not found.
+func (m IngredientModel) Get(orgID, id int64) (*Ingredient, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, organization_id, name, allergens, diets, version
+		FROM ingredients
+		WHERE id = $1 AND organization_id = $2`
+
+	var ingredient Ingredient
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, orgID).Scan(
+		&ingredient.ID,
+		&ingredient.CreatedAt,
+		&ingredient.OrganizationID,
+		&ingredient.Name,
+		pq.Array(&ingredient.Allergens),
+		pq.Array(&ingredient.Diets),
+		&ingredient.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &ingredient, nil
+}
+
+func (m IngredientModel) GetAll(orgID int64, name string, filters Filters) ([]*Ingredient, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, organization_id, name, allergens, diets, version
+		FROM ingredients
+		WHERE organization_id = $1
+		AND (to_tsvector('simple', name::text) @@ plainto_tsquery('simple', $2) OR $2 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, orgID, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	ingredients := []*Ingredient{}
+	for rows.Next() {
+		var ingredient Ingredient
+		err := rows.Scan(
+			&totalRecords,
+			&ingredient.ID,
+			&ingredient.CreatedAt,
+			&ingredient.OrganizationID,
+			&ingredient.Name,
+			pq.Array(&ingredient.Allergens),
+			pq.Array(&ingredient.Diets),
+			&ingredient.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		ingredients = append(ingredients, &ingredient)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return ingredients, metadata, nil
+}
+
+func (m IngredientModel) Update(ingredient *Ingredient) error {
+	query := `
+		UPDATE ingredients
+		SET name = $1, allergens = $2, diets = $3, version = version + 1
+		WHERE id = $4 AND organization_id = $5 AND version = $6
+		RETURNING version`
+
+	args := []any{
+		ingredient.Name,
+		pq.Array(ingredient.Allergens),
+		pq.Array(ingredient.Diets),
+		ingredient.ID,
+		ingredient.OrganizationID,
+		ingredient.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&ingredient.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "ingredients_organization_id_name_key"`:
+			return ErrDuplicateIngredient
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes an ingredient from the catalog. ErrIngredientInUse is returned
+// if any food still has it.
+func (m IngredientModel) Delete(orgID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `DELETE FROM ingredients WHERE id = $1 AND organization_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, orgID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: update or delete on table "ingredients" violates foreign key constraint "foods_ingredients_ingredient_id_fkey" on table "foods_ingredients"`:
+			return ErrIngredientInUse
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// LoginFailure counts the failed sign-in attempts for a single key, which is
+// either an email address or a client IP address (see EmailLoginKey and
+// IPLoginKey).
+type LoginFailure struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+type LoginFailureModel struct {
+	DB *sql.DB
+}
+
+func EmailLoginKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func IPLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Get returns the failures recorded for key. A key without any failures gives a
+// zero LoginFailure rather than ErrRecordNotFound.
+func (m LoginFailureModel) Get(key string) (*LoginFailure, error) {
+	query := `
+		SELECT key, failures, last_failure_at, locked_until
+		FROM login_failures
+		WHERE key = $1`
+
+	failure := LoginFailure{Key: key}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(
+		&failure.Key,
+		&failure.Failures,
+		&failure.LastFailureAt,
+		&failure.LockedUntil,
+	)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return &failure, nil
+}
+
+// Record adds a failed attempt for key and returns the new count, in a single
+// statement so that attempts made at the same time are each counted. If the
+// previous failure is older than window the count starts again from one and any
+// expired lock is cleared.
+func (m LoginFailureModel) Record(key string, window time.Duration) (*LoginFailure, error) {
+	query := `
+		INSERT INTO login_failures (key)
+		VALUES ($1)
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE WHEN login_failures.last_failure_at < $2 THEN 1 ELSE login_failures.failures + 1 END,
+		    locked_until = CASE WHEN login_failures.last_failure_at < $2 THEN NULL ELSE login_failures.locked_until END,
+		    last_failure_at = NOW()
+		RETURNING key, failures, last_failure_at, locked_until`
+
+	var failure LoginFailure
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key, time.Now().Add(-window)).Scan(
+		&failure.Key,
+		&failure.Failures,
+		&failure.LastFailureAt,
+		&failure.LockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &failure, nil
+}
+
+func (m LoginFailureModel) Lock(key string, until time.Time) error {
+	query := `
+		UPDATE login_failures
+		SET locked_until = $2
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key, until)
+	return err
+}
+
+// Forgive takes one failed attempt off the count for key, for an attempt that
+// was recorded before it was known to have succeeded.
+func (m LoginFailureModel) Forgive(key string) error {
+	query := `
+		UPDATE login_failures
+		SET failures = failures - 1
+		WHERE key = $1 AND failures > 0`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
+
+// Delete clears the failures for key, which also lifts any lockout.
+func (m LoginFailureModel) Delete(key string) error {
+	query := `
+		DELETE FROM login_failures
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
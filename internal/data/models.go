@@ -11,17 +11,41 @@ var (
 )
 
 type Models struct {
-	Tokens TokenModel
-	Users  UserModel
-	Foods  FoodModel
-	Sales  SaleModel
+	APIKeys       APIKeyModel
+	Audit         AuditModel
+	Categories    CategoryModel
+	Contacts      ContactModel
+	Ingredients   IngredientModel
+	LoginFailures LoginFailureModel
+	Organizations OrganizationModel
+	Passkeys      PasskeyModel
+	Passwords     PasswordHistoryModel
+	Permissions   PermissionModel
+	Tokens        TokenModel
+	TOTP          TOTPModel
+	Users         UserModel
+	Foods         FoodModel
+	FoodImages    FoodImageModel
+	Sales         SaleModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Tokens: TokenModel{DB: db},
-		Users:  UserModel{DB: db},
-		Foods:  FoodModel{DB: db},
-		Sales:  SaleModel{DB: db},
+		APIKeys:       APIKeyModel{DB: db},
+		Audit:         AuditModel{DB: db},
+		Categories:    CategoryModel{DB: db},
+		Contacts:      ContactModel{DB: db},
+		Ingredients:   IngredientModel{DB: db},
+		LoginFailures: LoginFailureModel{DB: db},
+		Organizations: OrganizationModel{DB: db},
+		Passkeys:      PasskeyModel{DB: db},
+		Passwords:     PasswordHistoryModel{DB: db},
+		Permissions:   PermissionModel{DB: db},
+		Tokens:        TokenModel{DB: db},
+		TOTP:          TOTPModel{DB: db},
+		Users:         UserModel{DB: db},
+		Foods:         FoodModel{DB: db},
+		FoodImages:    FoodImageModel{DB: db},
+		Sales:         SaleModel{DB: db},
 	}
 }
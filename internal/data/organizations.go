@@ -0,0 +1,121 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/laldil/greenlight/internal/validator"
+)
+
+// DefaultOrganizationID is the organization that requests belong to when
+// nothing else says which restaurant they are for. Everything created before
+// organizations existed was moved into it.
+const DefaultOrganizationID = 1
+
+// Organization is a restaurant. Foods, sales and users each belong to exactly
+// one organization, and can't be seen from any other.
+type Organization struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	TimeZone  string    `json:"time_zone"`
+	Version   int32     `json:"-"`
+}
+
+// Location returns the organization's time zone, which its food schedules are
+// in.
+func (o *Organization) Location() (*time.Location, error) {
+	return time.LoadLocation(o.TimeZone)
+}
+
+func ValidateOrganization(v *validator.Validator, organization *Organization) {
+	v.Check(organization.Name != "", "name", "must be provided")
+	v.Check(len(organization.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	// LoadLocation treats "" as UTC and "Local" as the server's own zone, neither
+	// of which is a restaurant's time zone.
+	v.Check(organization.TimeZone != "", "time_zone", "must be provided")
+	_, err := time.LoadLocation(organization.TimeZone)
+	v.Check(err == nil && organization.TimeZone != "Local", "time_zone", "must be a time zone name such as Asia/Almaty")
+}
+
+type OrganizationModel struct {
+	DB *sql.DB
+}
+
+func (m OrganizationModel) Get(id int64) (*Organization, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, name, slug, time_zone, version
+		FROM organizations
+		WHERE id = $1`
+
+	return m.get(query, id)
+}
+
+func (m OrganizationModel) GetBySlug(slug string) (*Organization, error) {
+	query := `
+		SELECT id, created_at, name, slug, time_zone, version
+		FROM organizations
+		WHERE slug = $1`
+
+	return m.get(query, slug)
+}
+
+func (m OrganizationModel) get(query string, arg any) (*Organization, error) {
+	var organization Organization
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, arg).Scan(
+		&organization.ID,
+		&organization.CreatedAt,
+		&organization.Name,
+		&organization.Slug,
+		&organization.TimeZone,
+		&organization.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &organization, nil
+}
+
+// Update saves the organization's name and time zone. The slug can't be changed,
+// since clients use it to find the organization.
+func (m OrganizationModel) Update(organization *Organization) error {
+	query := `
+		UPDATE organizations
+		SET name = $1, time_zone = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	args := []any{organization.Name, organization.TimeZone, organization.ID, organization.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&organization.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
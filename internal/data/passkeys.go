@@ -0,0 +1,191 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/laldil/greenlight/internal/validator"
+)
+
+var ErrDuplicatePasskey = errors.New("duplicate passkey")
+
+// Passkey is a WebAuthn credential that a user has registered for logging in.
+type Passkey struct {
+	ID           int64      `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UserID       int64      `json:"-"`
+	Name         string     `json:"name"`
+	CredentialID []byte     `json:"-"`
+	PublicKey    []byte     `json:"-"`
+	SignCount    int64      `json:"-"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+type PasskeyModel struct {
+	DB *sql.DB
+}
+
+func ValidatePasskey(v *validator.Validator, passkey *Passkey) {
+	v.Check(passkey.Name != "", "name", "must be provided")
+	v.Check(len(passkey.Name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+func (m PasskeyModel) Insert(passkey *Passkey) error {
+	query := `
+		INSERT INTO passkeys (user_id, name, credential_id, public_key, sign_count)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	args := []any{passkey.UserID, passkey.Name, passkey.CredentialID, passkey.PublicKey, passkey.SignCount}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&passkey.ID, &passkey.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "passkeys_credential_id_key"`:
+			return ErrDuplicatePasskey
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m PasskeyModel) GetAllForUser(userID int64) ([]*Passkey, error) {
+	query := `
+		SELECT id, created_at, user_id, name, credential_id, public_key, sign_count, last_used_at
+		FROM passkeys
+		WHERE user_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []*Passkey{}
+	for rows.Next() {
+		var passkey Passkey
+		err := rows.Scan(
+			&passkey.ID,
+			&passkey.CreatedAt,
+			&passkey.UserID,
+			&passkey.Name,
+			&passkey.CredentialID,
+			&passkey.PublicKey,
+			&passkey.SignCount,
+			&passkey.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		passkeys = append(passkeys, &passkey)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return passkeys, nil
+}
+
+// GetForCredentialID looks up a passkey by the ID the authenticator gave it, as
+// long as it belongs to the given user.
+func (m PasskeyModel) GetForCredentialID(credentialID []byte, userID int64) (*Passkey, error) {
+	query := `
+		SELECT id, created_at, user_id, name, credential_id, public_key, sign_count, last_used_at
+		FROM passkeys
+		WHERE credential_id = $1 AND user_id = $2`
+
+	var passkey Passkey
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, credentialID, userID).Scan(
+		&passkey.ID,
+		&passkey.CreatedAt,
+		&passkey.UserID,
+		&passkey.Name,
+		&passkey.CredentialID,
+		&passkey.PublicKey,
+		&passkey.SignCount,
+		&passkey.LastUsedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &passkey, nil
+}
+
+// UpdateSignCount stores the authenticator's new signature counter after a
+// successful login. The counter may only move forwards, so a concurrent login
+// with a cloned credential is reported as ErrEditConflict.
+func (m PasskeyModel) UpdateSignCount(passkey *Passkey, signCount int64) error {
+	query := `
+		UPDATE passkeys
+		SET sign_count = $1, last_used_at = NOW()
+		WHERE id = $2 AND (sign_count < $1 OR sign_count = 0)
+		RETURNING last_used_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, signCount, passkey.ID).Scan(&passkey.LastUsedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	passkey.SignCount = signCount
+	return nil
+}
+
+// DeleteForUser removes a passkey, as long as it belongs to the given user.
+func (m PasskeyModel) DeleteForUser(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM passkeys
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package data
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	_ "embed"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/laldil/greenlight/internal/validator"
+)
+
+// The password policy is set once at startup from the command-line flags.
+var (
+	PasswordCost       = 12
+	PasswordMinEntropy = 40.0
+)
+
+// commonPasswordsFile lists frequently used passwords, lowercased, together
+// with the variations people usually make of them: a number or year added to
+// the end, or letters swapped for look-alike digits and symbols. Only passwords
+// of 8 to 72 bytes are listed, as no others can be chosen anyway.
+//
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+var commonPasswords = func() map[string]bool {
+	passwords := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(commonPasswordsFile))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			passwords[strings.ToLower(line)] = true
+		}
+	}
+	return passwords
+}()
+
+// passwordEntropy is a rough estimate of a password's strength in bits, based on
+// its length and the kinds of characters it uses. Repeated characters only
+// count once after the first repeat, so "aaaaaaaaaaaa" doesn't score as a long
+// password.
+func passwordEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	counts := make(map[rune]int)
+	length := 0
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r) && r < unicode.MaxASCII:
+			lower = true
+		case unicode.IsUpper(r) && r < unicode.MaxASCII:
+			upper = true
+		case unicode.IsDigit(r) && r < unicode.MaxASCII:
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+
+		counts[r]++
+		if counts[r] <= 2 {
+			length++
+		}
+	}
+
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	return float64(length) * math.Log2(float64(pool))
+}
+
+// ValidatePasswordStrength applies the password policy to a new password. It is
+// not used at login, so that existing passwords keep working after the policy
+// is tightened.
+func ValidatePasswordStrength(v *validator.Validator, password string) {
+	v.Check(!commonPasswords[strings.ToLower(password)], "password", "is too common, please choose another")
+	v.Check(passwordEntropy(password) >= PasswordMinEntropy, "password", "is too easy to guess, try a longer password or more kinds of characters")
+}
+
+type PasswordHistoryModel struct {
+	DB *sql.DB
+}
+
+// passwordWindow returns the hashes that a new password mustn't match: the
+// current one and the n most recent of the previous ones in history, which is
+// ordered newest first. A window of zero is empty, so reuse is allowed.
+func passwordWindow(current []byte, history [][]byte, n int) [][]byte {
+	if n <= 0 {
+		return nil
+	}
+	if len(history) > n {
+		history = history[:n]
+	}
+
+	window := make([][]byte, 0, len(history)+1)
+	if current != nil {
+		window = append(window, current)
+	}
+	return append(window, history...)
+}
+
+// Reused reports whether plaintext matches the user's current password or one
+// of their last n previous ones. A limit of zero turns the check off.
+func (m PasswordHistoryModel) Reused(user *User, plaintext string, n int) (bool, error) {
+	if n <= 0 {
+		return false, nil
+	}
+
+	query := `
+		SELECT password_hash
+		FROM password_history
+		WHERE user_id = $1
+		ORDER BY id DESC
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, user.ID, n)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	var hashes [][]byte
+	for rows.Next() {
+		var hash []byte
+		err := rows.Scan(&hash)
+		if err != nil {
+			return false, err
+		}
+		hashes = append(hashes, hash)
+	}
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+
+	for _, hash := range passwordWindow(user.Password.hash, hashes, n) {
+		match, err := (&password{hash: hash}).Matches(plaintext)
+		if err != nil || match {
+			return match, err
+		}
+	}
+
+	return false, nil
+}
+
+// Insert adds the hash that the user's last call to Password.Set replaced to
+// their history, and forgets anything older than the last n entries. Together
+// with the current password, that is the window that Reused checks.
+func (m PasswordHistoryModel) Insert(user *User, n int) error {
+	if user.Password.previous == nil || n <= 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO password_history (user_id, password_hash)
+		VALUES ($1, $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, user.ID, user.Password.previous)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM password_history
+		WHERE user_id = $1
+		AND id NOT IN (
+			SELECT id FROM password_history
+			WHERE user_id = $1
+			ORDER BY id DESC
+			LIMIT $2
+		)`
+
+	_, err = m.DB.ExecContext(ctx, query, user.ID, n)
+	return err
+}
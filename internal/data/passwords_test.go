@@ -0,0 +1,66 @@
+package data
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPasswordWindow(t *testing.T) {
+	const n = 3
+
+	// Change the password six times, keeping the history the way
+	// PasswordHistoryModel.Insert does: newest first, trimmed to n entries.
+	var current []byte
+	var history [][]byte
+	for i := 0; i < 6; i++ {
+		if current != nil {
+			history = append([][]byte{current}, history...)
+			if len(history) > n {
+				history = history[:n]
+			}
+		}
+		current = []byte(fmt.Sprintf("hash%d", i))
+	}
+
+	window := passwordWindow(current, history, n)
+
+	inWindow := func(hash string) bool {
+		for _, h := range window {
+			if string(h) == hash {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The current password and the n before it can't be reused; the one before
+	// those can.
+	for _, hash := range []string{"hash5", "hash4", "hash3", "hash2"} {
+		if !inWindow(hash) {
+			t.Errorf("%s: not in window; want it reused", hash)
+		}
+	}
+	for _, hash := range []string{"hash1", "hash0"} {
+		if inWindow(hash) {
+			t.Errorf("%s: in window; want it allowed", hash)
+		}
+	}
+	if len(window) != n+1 {
+		t.Errorf("got %d hashes in window; want %d", len(window), n+1)
+	}
+}
+
+func TestPasswordWindowLimits(t *testing.T) {
+	history := [][]byte{[]byte("hash1"), []byte("hash0")}
+
+	if window := passwordWindow([]byte("hash2"), history, 0); len(window) != 0 {
+		t.Errorf("n = 0: got %d hashes; want none", len(window))
+	}
+
+	// A history longer than n, such as after the limit was lowered, is cut to
+	// the newest entries.
+	window := passwordWindow([]byte("hash2"), history, 1)
+	if len(window) != 2 || string(window[0]) != "hash2" || string(window[1]) != "hash1" {
+		t.Errorf("n = 1: got %q; want [hash2 hash1]", window)
+	}
+}
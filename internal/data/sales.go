@@ -11,13 +11,16 @@ import (
 )
 
 type Sale struct {
-	ID          int64     `json:"id"`
-	CreatedAt   time.Time `json:"-"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Duration    Runtime   `json:"duration,omitempty"`
-	Foodsale    []string  `json:"foodsale,omitempty"`
-	Version     int32     `json:"version"`
+	ID             int64     `json:"id"`
+	CreatedAt      time.Time `json:"-"`
+	OrganizationID int64     `json:"-"`
+	Title          string    `json:"title"`
+	Description    string    `json:"description"`
+	Duration       Runtime   `json:"duration,omitempty"`
+	Foodsale       []string  `json:"foodsale,omitempty"`
+	CreatedBy      *int64    `json:"created_by"`
+	UpdatedBy      *int64    `json:"updated_by"`
+	Version        int32     `json:"version"`
 }
 
 type SaleModel struct {
@@ -26,10 +29,10 @@ type SaleModel struct {
 
 func (m SaleModel) Insert(sale *Sale) error {
 	query :=
-		`INSERT INTO sales (title, description, duration, foodsale)
-		 VALUES ($1, $2, $3, $4)
+		`INSERT INTO sales (organization_id, title, description, duration, foodsale, created_by, updated_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
 		 RETURNING id, created_at, version`
-	args := []any{sale.Title, sale.Description, sale.Duration, pq.Array(sale.Foodsale)}
+	args := []any{sale.OrganizationID, sale.Title, sale.Description, sale.Duration, pq.Array(sale.Foodsale), sale.CreatedBy, sale.UpdatedBy}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -37,23 +40,31 @@ func (m SaleModel) Insert(sale *Sale) error {
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&sale.ID, &sale.CreatedAt, &sale.Version)
 }
 
-func (m SaleModel) Get(id int64) (*Sale, error) {
+// Get returns a sale from the given organization. Sales belonging to other
+// organizations are reported as not found.
+func (m SaleModel) Get(orgID, id int64) (*Sale, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `SELECT * FROM sales WHERE id = $1`
+	query := `
+		SELECT id, created_at, organization_id, title, description, duration, foodsale, created_by, updated_by, version
+		FROM sales
+		WHERE id = $1 AND organization_id = $2`
 
 	var sale Sale
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.DB.QueryRowContext(ctx, query, id, orgID).Scan(
 		&sale.ID,
 		&sale.CreatedAt,
+		&sale.OrganizationID,
 		&sale.Title,
 		&sale.Description,
 		&sale.Duration,
 		pq.Array(&sale.Foodsale),
+		&sale.CreatedBy,
+		&sale.UpdatedBy,
 		&sale.Version,
 	)
 
@@ -69,19 +80,20 @@ func (m SaleModel) Get(id int64) (*Sale, error) {
 	return &sale, nil
 }
 
-func (m SaleModel) GetAll(title string, foodsale []string, filters Filters) ([]*Sale, Metadata, error) {
+func (m SaleModel) GetAll(orgID int64, title string, foodsale []string, filters Filters) ([]*Sale, Metadata, error) {
 	query := fmt.Sprintf(` 
-		SELECT COUNT(*) OVER(), id, created_at, title, description, duration, foodsale, version
+		SELECT COUNT(*) OVER(), id, created_at, organization_id, title, description, duration, foodsale, created_by, updated_by, version
 		FROM sales 
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND (foodsale @> $2 OR $2 = '{}')
+		WHERE organization_id = $1
+		AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $2) OR $2 = '')
+		AND (foodsale @> $3 OR $3 = '{}')
 		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{title, pq.Array(foodsale), filters.limit(), filters.offset()}
+	args := []any{orgID, title, pq.Array(foodsale), filters.limit(), filters.offset()}
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -98,10 +110,13 @@ func (m SaleModel) GetAll(title string, foodsale []string, filters Filters) ([]*
 			&totalRecords,
 			&sale.ID,
 			&sale.CreatedAt,
+			&sale.OrganizationID,
 			&sale.Title,
 			&sale.Description,
 			&sale.Duration,
 			pq.Array(&sale.Foodsale),
+			&sale.CreatedBy,
+			&sale.UpdatedBy,
 			&sale.Version,
 		)
 		if err != nil {
@@ -122,8 +137,8 @@ func (m SaleModel) GetAll(title string, foodsale []string, filters Filters) ([]*
 func (m SaleModel) Update(sale *Sale) error {
 	query :=
 		`UPDATE sales
-		 SET title = $1, description = $2, duration = $3, foodsale = $4, version = version + 1
-		 WHERE id = $5 AND version = $6
+		 SET title = $1, description = $2, duration = $3, foodsale = $4, updated_by = $5, version = version + 1
+		 WHERE id = $6 AND organization_id = $7 AND version = $8
 		 RETURNING version`
 
 	args := []any{
@@ -131,7 +146,9 @@ func (m SaleModel) Update(sale *Sale) error {
 		sale.Description,
 		sale.Duration,
 		pq.Array(sale.Foodsale),
+		sale.UpdatedBy,
 		sale.ID,
+		sale.OrganizationID,
 		sale.Version,
 	}
 
@@ -151,16 +168,16 @@ func (m SaleModel) Update(sale *Sale) error {
 	return nil
 }
 
-func (m SaleModel) Delete(id int64) error {
+func (m SaleModel) Delete(orgID, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
-	query := `DELETE FROM sales WHERE id = $1`
+	query := `DELETE FROM sales WHERE id = $1 AND organization_id = $2`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id, orgID)
 	if err != nil {
 		return err
 	}
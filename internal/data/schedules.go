@@ -0,0 +1,163 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/laldil/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// weekdays are the names used for days of the week in schedules, indexed by
+// time.Weekday.
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ScheduleWindow is a weekly period when a food can be ordered, such as
+// breakfast from "07:00" to "11:00" on "mon" to "fri". Times are in the
+// restaurant's time zone, and "24:00" can be used for the end of the day. A
+// window that ends before it starts, such as "22:00" to "02:00", runs overnight
+// into the next day.
+type ScheduleWindow struct {
+	Days []string `json:"days"`
+	From string   `json:"from"`
+	To   string   `json:"to"`
+}
+
+// Schedule is the set of windows when a food can be ordered. An empty schedule
+// means it can be ordered at any time.
+type Schedule []ScheduleWindow
+
+// Scan reads a schedule from the JSON built by foodColumns, where days are
+// numbers and times are minutes since midnight.
+func (s *Schedule) Scan(src any) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into Schedule", src)
+	}
+
+	var rows []struct {
+		Days     []int `json:"days"`
+		StartsAt int   `json:"starts_at"`
+		EndsAt   int   `json:"ends_at"`
+	}
+
+	err := json.Unmarshal(b, &rows)
+	if err != nil {
+		return err
+	}
+
+	schedule := make(Schedule, len(rows))
+	for i, row := range rows {
+		window := ScheduleWindow{
+			Days: make([]string, len(row.Days)),
+			From: formatClock(row.StartsAt),
+			To:   formatClock(row.EndsAt),
+		}
+		for j, day := range row.Days {
+			if day < 0 || day >= len(weekdays) {
+				return fmt.Errorf("invalid day %d in schedule", day)
+			}
+			window.Days[j] = weekdays[day]
+		}
+		schedule[i] = window
+	}
+
+	*s = schedule
+	return nil
+}
+
+func ValidateSchedule(v *validator.Validator, schedule Schedule) {
+	v.Check(len(schedule) <= 20, "schedule", "must not contain more than 20 windows")
+
+	for i, window := range schedule {
+		key := fmt.Sprintf("schedule[%d]", i)
+
+		v.Check(len(window.Days) > 0, key, "days must be provided")
+		v.Check(validator.Unique(window.Days), key, "days must not contain duplicate values")
+		for _, day := range window.Days {
+			v.Check(validator.PermittedValue(day, weekdays...), key, "days must only contain 'mon', 'tue', 'wed', 'thu', 'fri', 'sat' or 'sun'")
+		}
+
+		from, fromOK := parseClock(window.From)
+		to, toOK := parseClock(window.To)
+		v.Check(fromOK && from < 24*60, key, "from must be a time like 07:00")
+		v.Check(toOK, key, "to must be a time like 11:00")
+		v.Check(!fromOK || !toOK || from != to, key, "to must be different from from")
+	}
+}
+
+// parseClock converts a time like "07:30" into minutes since midnight. "24:00"
+// is allowed for the end of the day.
+func parseClock(s string) (int, bool) {
+	hours, minutes, ok := strings.Cut(s, ":")
+	if !ok || len(hours) != 2 || len(minutes) != 2 {
+		return 0, false
+	}
+
+	h, err := strconv.Atoi(hours)
+	if err != nil {
+		return 0, false
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil {
+		return 0, false
+	}
+
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, false
+	}
+
+	return h*60 + m, true
+}
+
+func formatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// weekdayNumber returns the number of a day named in a schedule.
+func weekdayNumber(day string) int {
+	for i := range weekdays {
+		if weekdays[i] == day {
+			return i
+		}
+	}
+	return -1
+}
+
+// setFoodSchedule replaces the windows when the food can be ordered. The
+// schedule must already have been validated.
+func setFoodSchedule(ctx context.Context, tx *sql.Tx, food *Food) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM food_schedules WHERE food_id = $1`, food.ID)
+	if err != nil {
+		return err
+	}
+
+	if food.Schedule == nil {
+		food.Schedule = Schedule{}
+	}
+
+	query := `
+		INSERT INTO food_schedules (food_id, days, starts_at, ends_at)
+		VALUES ($1, $2, $3, $4)`
+
+	for _, window := range food.Schedule {
+		days := make([]int64, len(window.Days))
+		for i, day := range window.Days {
+			days[i] = int64(weekdayNumber(day))
+		}
+
+		from, _ := parseClock(window.From)
+		to, _ := parseClock(window.To)
+
+		_, err = tx.ExecContext(ctx, query, food.ID, pq.Array(days), from, to)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -6,22 +6,53 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"github.com/laldil/greenlight/internal/validator"
+	"github.com/lib/pq"
 )
 
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeInvitation     = "invitation"
+	ScopeEmailChange    = "email-change"
+	ScopeMFAPending     = "mfa-pending"
+	ScopeRefresh        = "refresh"
+	ScopeMagicLink      = "magic-link"
+	// Passkey ceremonies use the plaintext of a short-lived token as their
+	// WebAuthn challenge.
+	ScopePasskeyRegistration = "passkey-registration"
+	ScopePasskeyLogin        = "passkey-login"
 )
 
+// ErrRefreshTokenReused is returned when a refresh token that has already been
+// exchanged is presented again, which means it has probably been stolen.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
 type Token struct {
+	ID        int64     `json:"-"`
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
+	CreatedAt time.Time `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	UserAgent string    `json:"-"`
+	Family    []byte    `json:"-"`
+}
+
+// Session describes an authentication token without exposing the token itself,
+// so that users can see where they are signed in.
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	UserAgent  string     `json:"user_agent"`
+	Expiry     time.Time  `json:"expiry"`
+	Current    bool       `json:"current"`
 }
 
 type TokenModel struct {
@@ -64,20 +95,318 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewSession creates an authentication token and records the user agent of the
+// client it was issued to.
+func (m TokenModel) NewSession(userID int64, ttl time.Duration, userAgent string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+	token.UserAgent = userAgent
+
+	err = m.Insert(token)
+
+	return token, err
+}
+
+// NewRefresh creates a refresh token in the given family. Every token issued by
+// rotating a refresh token stays in the family of the login that started it; a
+// nil family starts a new one.
+func (m TokenModel) NewRefresh(userID int64, ttl time.Duration, family []byte, userAgent string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	token.UserAgent = userAgent
+	token.Family = family
+	if token.Family == nil {
+		token.Family = token.Hash
+	}
+
+	err = m.Insert(token)
+
+	return token, err
+}
+
 func (m TokenModel) Insert(token *Token) error {
 	query :=
-		`INSERT INTO tokens(hash, user_id, expiry, scope) 
-    	 VALUES ($1, $2, $3, $4)`
+		`INSERT INTO tokens(hash, user_id, expiry, scope, user_agent, family) 
+    	 VALUES ($1, $2, $3, $4, $5, $6)
+    	 RETURNING id, created_at`
+
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.Family}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+}
+
+// UseRefreshToken marks a refresh token as used and returns it, so that a new
+// one can be issued in the same family. Presenting a token that was already
+// used revokes its whole family and returns ErrRefreshTokenReused.
+func (m TokenModel) UseRefreshToken(tokenPlaintext string) (*Token, error) {
+	query := `
+		UPDATE tokens
+		SET used_at = NOW()
+		WHERE hash = $1 AND scope = $2 AND expiry > $3 AND used_at IS NULL
+		RETURNING id, user_id, created_at, expiry, user_agent, family`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	token := Token{Plaintext: tokenPlaintext, Hash: tokenHash[:], Scope: ScopeRefresh}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh, time.Now()).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.CreatedAt,
+		&token.Expiry,
+		&token.UserAgent,
+		&token.Family,
+	)
+	if err == nil {
+		return &token, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// The token is unknown, expired or already used. Used tokens are kept until
+	// they expire precisely so that a replay can be told apart from the others.
+	query = `
+		DELETE FROM tokens
+		WHERE family = (SELECT family FROM tokens WHERE hash = $1 AND scope = $2 AND used_at IS NOT NULL)`
+
+	result, err := m.DB.ExecContext(ctx, query, tokenHash[:], ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected > 0 {
+		return nil, ErrRefreshTokenReused
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+// DeleteRefreshFamily revokes the refresh token and every other token in its
+// family, which signs out the login that it belongs to.
+func (m TokenModel) DeleteRefreshFamily(tokenPlaintext string) error {
+	query := `
+		DELETE FROM tokens
+		WHERE family = (SELECT family FROM tokens WHERE hash = $1 AND scope = $2)`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:], ScopeRefresh)
+	return err
+}
+
+// DeleteRefreshFamilyForUser revokes every refresh token in a family, which
+// signs out the login it belongs to. Families belonging to other users are left
+// alone.
+func (m TokenModel) DeleteRefreshFamilyForUser(family []byte, userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE family = $1 AND user_id = $2 AND scope = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, family, userID, ScopeRefresh)
+	return err
+}
+
+// SessionStartedAt returns when an authentication token was issued, which is
+// when the user logged in.
+func (m TokenModel) SessionStartedAt(tokenPlaintext string) (time.Time, error) {
+	query := `
+		SELECT created_at
+		FROM tokens
+		WHERE hash = $1 AND scope = $2`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var createdAt time.Time
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeAuthentication).Scan(&createdAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, ErrRecordNotFound
+		default:
+			return time.Time{}, err
+		}
+	}
+
+	return createdAt, nil
+}
+
+// RefreshFamilyStartedAt returns when the login that a family of refresh tokens
+// belongs to started. That's when the family's first token, whose hash the
+// family is named after, was issued.
+func (m TokenModel) RefreshFamilyStartedAt(family []byte) (time.Time, error) {
+	query := `
+		SELECT created_at
+		FROM tokens
+		WHERE hash = $1 AND family = $1 AND scope = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var createdAt time.Time
+
+	err := m.DB.QueryRowContext(ctx, query, family, ScopeRefresh).Scan(&createdAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, ErrRecordNotFound
+		default:
+			return time.Time{}, err
+		}
+	}
+
+	return createdAt, nil
+}
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+// GetSessionsForUser returns the user's signed-in sessions: their unexpired
+// authentication tokens and unused refresh tokens. The one matching
+// currentPlaintext is marked as the current session.
+func (m TokenModel) GetSessionsForUser(userID int64, currentPlaintext string) ([]*Session, error) {
+	query := `
+		SELECT id, created_at, last_used_at, user_agent, expiry, hash = $4
+		FROM tokens
+		WHERE user_id = $1 AND scope = ANY($2) AND expiry > $3 AND used_at IS NULL
+		ORDER BY created_at DESC, id DESC`
+
+	currentHash := sha256.Sum256([]byte(currentPlaintext))
+	args := []any{userID, pq.Array([]string{ScopeAuthentication, ScopeRefresh}), time.Now(), currentHash[:]}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.UserAgent,
+			&session.Expiry,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// UpdateLastUsed records that a token has just been used. To avoid a write on
+// every single request, the timestamp is only moved forward once a minute.
+func (m TokenModel) UpdateLastUsed(scope, tokenPlaintext string) error {
+	query := `
+		UPDATE tokens
+		SET last_used_at = NOW()
+		WHERE hash = $1 AND scope = $2
+		AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope)
 	return err
 }
 
+func (m TokenModel) Delete(scope, tokenPlaintext string) error {
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope)
+	return err
+}
+
+// DeleteSessionsForUser signs the user out everywhere by deleting all of their
+// authentication and refresh tokens.
+func (m TokenModel) DeleteSessionsForUser(userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1 AND scope = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array([]string{ScopeAuthentication, ScopeRefresh}))
+	return err
+}
+
+// DeleteSessionForUser revokes a single session by its id. For a refresh token
+// the rest of its family goes too. The user id is part of the WHERE clause so
+// that users can only revoke their own sessions.
+func (m TokenModel) DeleteSessionForUser(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $2 AND scope = ANY($3)
+		AND (id = $1 OR family = (SELECT family FROM tokens WHERE id = $1 AND scope = $4))`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, pq.Array([]string{ScopeAuthentication, ScopeRefresh}), ScopeRefresh)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
 func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	query := `
 		DELETE FROM tokens
@@ -0,0 +1,204 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TOTP is a user's authenticator app secret. It only protects logins once it
+// has been enabled by confirming a first code. LastCounter is the time step of
+// the last code that was accepted, since each code may only be used once.
+type TOTP struct {
+	UserID      int64
+	CreatedAt   time.Time
+	Secret      string
+	Enabled     bool
+	LastCounter *int64
+}
+
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+func (m TOTPModel) Get(userID int64) (*TOTP, error) {
+	query := `
+		SELECT user_id, created_at, secret, enabled, last_counter
+		FROM users_totp
+		WHERE user_id = $1`
+
+	var totp TOTP
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.CreatedAt,
+		&totp.Secret,
+		&totp.Enabled,
+		&totp.LastCounter,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &totp, nil
+}
+
+// Enroll stores a new, not yet enabled, secret for the user, replacing any
+// earlier enrollment that was never confirmed.
+func (m TOTPModel) Enroll(totp *TOTP) error {
+	query := `
+		INSERT INTO users_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, created_at = NOW(), enabled = false, last_counter = NULL
+		RETURNING created_at, enabled`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, totp.UserID, totp.Secret).Scan(&totp.CreatedAt, &totp.Enabled)
+}
+
+// Enable turns on two-factor authentication for the user and replaces their
+// recovery codes with the given ones. counter is the time step of the code that
+// confirmed the enrollment, which can't be used again. ErrEditConflict is
+// returned if it already has been.
+func (m TOTPModel) Enable(userID int64, counter int64, recoveryCodes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users_totp
+		SET enabled = true, last_counter = $2
+		WHERE user_id = $1 AND NOT enabled AND (last_counter IS NULL OR last_counter < $2)`, userID, counter)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	hashes := make([][]byte, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO recovery_codes (hash, user_id)
+		SELECT unnest($1::bytea[]), $2`, pq.Array(hashes), userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseCounter records that a code for the given time step has been accepted,
+// reporting false if that step or a later one was already used. The check and
+// the update are a single statement, so a code replayed concurrently is still
+// only accepted once.
+func (m TOTPModel) UseCounter(userID int64, counter int64) (bool, error) {
+	query := `
+		UPDATE users_totp
+		SET last_counter = $2
+		WHERE user_id = $1 AND enabled AND (last_counter IS NULL OR last_counter < $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, counter)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// UseRecoveryCode deletes the matching recovery code for the user, reporting
+// whether there was one.
+func (m TOTPModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	query := `
+		DELETE FROM recovery_codes
+		WHERE hash = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hashRecoveryCode(code), userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// Delete turns off two-factor authentication for the user.
+func (m TOTPModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// hashRecoveryCode ignores case and dashes, so "ABCDE-FGHIJ" and "abcdefghij"
+// are the same code.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
@@ -2,30 +2,48 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/base32"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/laldil/greenlight/internal/validator"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	ErrDuplicateEmail = errors.New("duplicate email")
+
+	// ErrLastAdmin is returned when a change would leave an organization
+	// without an active admin.
+	ErrLastAdmin = errors.New("last active admin")
+)
+
+const (
+	RoleUser    = "user"
+	RoleStaff   = "staff"
+	RoleManager = "manager"
+	RoleAdmin   = "admin"
 )
 
 var AnonymousUser = &User{}
 
 type User struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
-	Role      string    `json:"role"`
-	Version   int       `json:"-"`
+	ID             int64     `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	Name           string    `json:"name"`
+	Email          string    `json:"email"`
+	PendingEmail   string    `json:"pending_email,omitempty"`
+	Password       password  `json:"-"`
+	Activated      bool      `json:"activated"`
+	Disabled       bool      `json:"disabled"`
+	Role           string    `json:"role"`
+	OrganizationID int64     `json:"organization_id"`
+	Version        int       `json:"-"`
 }
 
 type UserModel struct {
@@ -35,6 +53,9 @@ type UserModel struct {
 type password struct {
 	plaintext *string
 	hash      []byte
+	// previous is the hash that Set replaced, so that it can be added to the
+	// user's password history once the change is saved.
+	previous []byte
 }
 
 func (u *User) IsAnonymous() bool {
@@ -42,18 +63,31 @@ func (u *User) IsAnonymous() bool {
 }
 
 func (m UserModel) Insert(user *User) error {
+	return m.InsertWithPermissions(user, nil)
+}
+
+// InsertWithPermissions creates the user and grants them the given permissions
+// in one transaction, so that an account never exists without them.
+func (m UserModel) InsertWithPermissions(user *User, permissions Permissions) error {
 	query := `
-INSERT INTO users (name, email, password_hash, activated, role)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO users (name, email, password_hash, activated, role, organization_id)
+VALUES ($1, $2, $3, $4, $5, $6)
 RETURNING id, created_at, version`
-	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated, user.Role}
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated, user.Role, user.OrganizationID}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// If the table already contains a record with this email address, then when we try
 	// to perform the insert there will be a violation of the UNIQUE "users_email_key"
 	// constraint that we set up in the previous chapter. We check for this error
 	// specifically, and return custom ErrDuplicateEmail error instead.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"` ||
@@ -63,12 +97,24 @@ RETURNING id, created_at, version`
 			return err
 		}
 	}
-	return nil
+
+	if len(permissions) > 0 {
+		query = `
+			INSERT INTO users_permissions
+			SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+		_, err = tx.ExecContext(ctx, query, user.ID, pq.Array([]string(permissions)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-SELECT id, created_at, name, email, password_hash, activated, version
+SELECT id, created_at, name, email, password_hash, activated, disabled, version, role, pending_email, organization_id
 FROM users
 WHERE email = $1`
 	var user User
@@ -81,7 +127,46 @@ WHERE email = $1`
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Disabled,
+		&user.Version,
+		&user.Role,
+		&user.PendingEmail,
+		&user.OrganizationID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, created_at, name, email, password_hash, activated, disabled, version, role, pending_email, organization_id
+FROM users
+WHERE id = $1`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Disabled,
 		&user.Version,
+		&user.Role,
+		&user.PendingEmail,
+		&user.OrganizationID,
 	)
 	if err != nil {
 		switch {
@@ -94,13 +179,84 @@ WHERE email = $1`
 	return &user, nil
 }
 
+// GetForOrganization is like Get, but treats users that belong to a different
+// organization as not found.
+func (m UserModel) GetForOrganization(id, orgID int64) (*User, error) {
+	user, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.OrganizationID != orgID {
+		return nil, ErrRecordNotFound
+	}
+
+	return user, nil
+}
+
+func (m UserModel) GetAll(orgID int64, name, email, role string, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT COUNT(*) OVER(), id, created_at, name, email, password_hash, activated, disabled, version, role, pending_email, organization_id
+FROM users
+WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+AND (email ILIKE '%%' || $2 || '%%' OR $2 = '')
+AND (role = $3 OR $3 = '')
+AND organization_id = $4
+ORDER BY %s %s, id ASC
+LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{name, email, role, orgID, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Disabled,
+			&user.Version,
+			&user.Role,
+			&user.PendingEmail,
+			&user.OrganizationID,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
 	// Remember that this returns a byte *array* with length 32, not a slice.
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	// Set up the SQL query.
 	query := `
-SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version,users.role
+SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.disabled, users.version, users.role, users.pending_email, users.organization_id
 FROM users
 INNER JOIN tokens
 ON users.id = tokens.user_id
@@ -126,8 +282,11 @@ AND tokens.expiry > $3`
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Disabled,
 		&user.Version,
 		&user.Role,
+		&user.PendingEmail,
+		&user.OrganizationID,
 	)
 	if err != nil {
 		switch {
@@ -144,14 +303,16 @@ AND tokens.expiry > $3`
 func (m UserModel) Update(user *User) error {
 	query := `
 UPDATE users
-SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-WHERE id = $5 AND version = $6
+SET name = $1, email = $2, password_hash = $3, activated = $4, role = $5, pending_email = $6, version = version + 1
+WHERE id = $7 AND version = $8
 RETURNING version`
 	args := []interface{}{
 		user.Name,
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Role,
+		user.PendingEmail,
 		user.ID,
 		user.Version,
 	}
@@ -172,17 +333,181 @@ RETURNING version`
 	return nil
 }
 
+// UpdateAccess saves a change an admin made to the user's role, or to whether
+// their account is disabled. If permissions isn't nil, they replace the ones the
+// user had. ErrLastAdmin is returned, and nothing is saved, if the user is their
+// organization's only active admin and would no longer be one. The organization's active admins are
+// locked while this is checked, so that two admins can't demote each other at
+// the same time.
+func (m UserModel) UpdateAccess(user *User, permissions Permissions) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if user.Role != RoleAdmin || !user.Activated || user.Disabled {
+		err = checkOtherAdmins(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+		UPDATE users
+		SET role = $1, disabled = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, user.Role, user.Disabled, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if permissions != nil {
+		_, err = tx.ExecContext(ctx, `DELETE FROM users_permissions WHERE user_id = $1`, user.ID)
+		if err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO users_permissions
+			SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+		_, err = tx.ExecContext(ctx, query, user.ID, pq.Array([]string(permissions)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// checkOtherAdmins locks the active admins of the user's organization and
+// returns ErrLastAdmin if the user is the only one.
+func checkOtherAdmins(ctx context.Context, tx *sql.Tx, user *User) error {
+	query := `
+		SELECT id FROM users
+		WHERE organization_id = $1 AND role = $2 AND activated AND NOT disabled
+		FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, query, user.OrganizationID, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	isAdmin, others := false, 0
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		if id == user.ID {
+			isAdmin = true
+		} else {
+			others++
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if isAdmin && others == 0 {
+		return ErrLastAdmin
+	}
+
+	return nil
+}
+
+// Delete removes a user for good. Everything linked to the account with a
+// foreign key, such as their tokens, permissions and passkeys, goes with it, as
+// do the contact messages sent from their address and its failed logins. Their
+// audit events are kept, still linked to the old user ID, but without the email
+// address. It all happens in one transaction, so an account is never left half
+// deleted. ErrLastAdmin is returned if they are their organization's only
+// active admin.
+func (m UserModel) Delete(user *User) error {
+	if user.ID < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkOtherAdmins(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM contacts WHERE LOWER(email) = LOWER($1)`, user.Email)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM login_failures WHERE key = $1`, EmailLoginKey(user.Email))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE audit_events SET actor_email = '' WHERE actor_id = $1`, user.ID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, user.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), PasswordCost)
 	if err != nil {
 		return err
 	}
 
 	p.plaintext = &plaintextPassword
+	p.previous = p.hash
 	p.hash = hash
 
 	return nil
 }
+
+// NeedsRehash reports whether the hash was made with a lower bcrypt cost than
+// the one currently configured.
+func (p *password) NeedsRehash() bool {
+	cost, err := bcrypt.Cost(p.hash)
+	return err == nil && cost < PasswordCost
+}
 func (p *password) Matches(plaintextPassword string) (bool, error) {
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
 
@@ -198,6 +523,24 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+// SetUnusable gives the user a random password that nobody knows. It is used for
+// accounts that are created before their owner has chosen a password.
+func (p *password) SetUnusable() error {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	err = p.Set(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+	if err != nil {
+		return err
+	}
+	p.plaintext = nil
+
+	return nil
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -211,8 +554,15 @@ func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 
 func ValidateRole(v *validator.Validator, role string) {
 	v.Check(role != "", "role", "must be provided")
-	v.Check(role == "admin" || role == "user", "role", "must be either 'admin' or 'user'")
-} //
+	v.Check(validator.PermittedValue(role, RoleUser, RoleStaff, RoleManager, RoleAdmin), "role", "must be one of 'user', 'staff', 'manager' or 'admin'")
+}
+
+// ValidateStaffRole checks the role given to an invited staff member. Customers
+// sign up by themselves, so "user" is not allowed here.
+func ValidateStaffRole(v *validator.Validator, role string) {
+	v.Check(role != "", "role", "must be provided")
+	v.Check(validator.PermittedValue(role, RoleStaff, RoleManager, RoleAdmin), "role", "must be one of 'staff', 'manager' or 'admin'")
+}
 
 func ValidateUser(v *validator.Validator, user *User) {
 	v.Check(user.Name != "", "name", "must be provided")
@@ -222,6 +572,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+		ValidatePasswordStrength(v, *user.Password.plaintext)
 	}
 	if user.Password.hash == nil {
 		panic("missing password hash for user")
@@ -0,0 +1,376 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "greenlight"
+	testClientSecret = "client-secret"
+	testCode         = "auth-code"
+	testVerifier     = "code-verifier"
+	testNonce        = "nonce"
+)
+
+// testProvider is a local stand-in for an OpenID provider. It serves discovery,
+// a key set and a token endpoint, and answers token requests with whatever ID
+// token idToken returns.
+type testProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	keys   map[string]*rsa.PrivateKey
+
+	idToken func(p *testProvider) string
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+
+	p := &testProvider{t: t, keys: map[string]*rsa.PrivateKey{"key-1": newKey(t)}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		var keys []map[string]string
+		for kid, key := range p.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"n":   encoding.EncodeToString(key.N.Bytes()),
+				"e":   encoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, secret, _ := r.BasicAuth()
+		switch {
+		case r.Method != http.MethodPost:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		case clientID != testClientID || secret != testClientSecret:
+			http.Error(w, "invalid_client", http.StatusUnauthorized)
+		case r.PostFormValue("grant_type") != "authorization_code",
+			r.PostFormValue("code") != testCode,
+			r.PostFormValue("code_verifier") != testVerifier:
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+		default:
+			json.NewEncoder(w).Encode(map[string]string{"id_token": p.idToken(p)})
+		}
+	})
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	p.idToken = func(p *testProvider) string {
+		return p.signRS256("key-1", p.claims(nil))
+	}
+
+	return p
+}
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func (p *testProvider) relyingParty() *Provider {
+	return New(Config{
+		Issuer:       p.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  "http://localhost:4000/v1/oidc/callback",
+	})
+}
+
+// claims returns a valid set of ID token claims, with any changes applied.
+func (p *testProvider) claims(changes map[string]any) map[string]any {
+	claims := map[string]any{
+		"iss":            p.server.URL,
+		"sub":            "1234",
+		"aud":            testClientID,
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"nonce":          testNonce,
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+	}
+	for name, value := range changes {
+		claims[name] = value
+	}
+	return claims
+}
+
+func (p *testProvider) signRS256(kid string, claims map[string]any) string {
+	signingInput := p.signingInput(map[string]string{"alg": "RS256", "kid": kid}, claims)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.keys[kid], crypto.SHA256, digest[:])
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	return signingInput + "." + encoding.EncodeToString(signature)
+}
+
+func (p *testProvider) signHS256(secret string, claims map[string]any) string {
+	signingInput := p.signingInput(map[string]string{"alg": "HS256"}, claims)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingInput))
+
+	return signingInput + "." + encoding.EncodeToString(mac.Sum(nil))
+}
+
+func (p *testProvider) signingInput(header map[string]string, claims map[string]any) string {
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	return encoding.EncodeToString(headerJSON) + "." + encoding.EncodeToString(claimsJSON)
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	p := newTestProvider(t)
+
+	authURL, err := p.relyingParty().AuthCodeURL(context.Background(), "state", testNonce, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != p.server.URL+"/authorize" {
+		t.Errorf("got endpoint %q; want %q", got, p.server.URL+"/authorize")
+	}
+
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          "http://localhost:4000/v1/oidc/callback",
+		"state":                 "state",
+		"nonce":                 testNonce,
+		"code_challenge":        CodeChallenge(testVerifier),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if got := u.Query().Get(name); got != value {
+			t.Errorf("got %s %q; want %q", name, got, value)
+		}
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// The example from RFC 7636 appendix B.
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	otherKey := newKey(t)
+
+	tests := []struct {
+		name    string
+		idToken func(p *testProvider) string
+		wantErr bool
+	}{
+		{
+			name: "valid RS256 token",
+			idToken: func(p *testProvider) string {
+				return p.signRS256("key-1", p.claims(nil))
+			},
+		},
+		{
+			name: "valid HS256 token",
+			idToken: func(p *testProvider) string {
+				return p.signHS256(testClientSecret, p.claims(nil))
+			},
+		},
+		{
+			name: "audience as an array",
+			idToken: func(p *testProvider) string {
+				return p.signRS256("key-1", p.claims(map[string]any{"aud": []string{"other", testClientID}}))
+			},
+		},
+		{
+			name: "email_verified as a string",
+			idToken: func(p *testProvider) string {
+				return p.signRS256("key-1", p.claims(map[string]any{"email_verified": "true"}))
+			},
+		},
+		{
+			name: "wrong nonce",
+			idToken: func(p *testProvider) string {
+				return p.signRS256("key-1", p.claims(map[string]any{"nonce": "replayed"}))
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong audience",
+			idToken: func(p *testProvider) string {
+				return p.signRS256("key-1", p.claims(map[string]any{"aud": "someone-else"}))
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong issuer",
+			idToken: func(p *testProvider) string {
+				return p.signRS256("key-1", p.claims(map[string]any{"iss": "https://evil.example.com"}))
+			},
+			wantErr: true,
+		},
+		{
+			name: "expired",
+			idToken: func(p *testProvider) string {
+				return p.signRS256("key-1", p.claims(map[string]any{"exp": time.Now().Add(-time.Minute).Unix()}))
+			},
+			wantErr: true,
+		},
+		{
+			name: "signed with an unknown key",
+			idToken: func(p *testProvider) string {
+				p.keys["key-2"] = otherKey
+				token := p.signRS256("key-2", p.claims(nil))
+				delete(p.keys, "key-2")
+				return token
+			},
+			wantErr: true,
+		},
+		{
+			name: "signed with another key under a known id",
+			idToken: func(p *testProvider) string {
+				real := p.keys["key-1"]
+				p.keys["key-1"] = otherKey
+				token := p.signRS256("key-1", p.claims(nil))
+				p.keys["key-1"] = real
+				return token
+			},
+			wantErr: true,
+		},
+		{
+			name: "HS256 with the wrong secret",
+			idToken: func(p *testProvider) string {
+				return p.signHS256("wrong-secret", p.claims(nil))
+			},
+			wantErr: true,
+		},
+		{
+			name: "unsigned",
+			idToken: func(p *testProvider) string {
+				return p.signingInput(map[string]string{"alg": "none"}, p.claims(nil)) + "."
+			},
+			wantErr: true,
+		},
+		{
+			name: "malformed",
+			idToken: func(p *testProvider) string {
+				return "not-a-jwt"
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t)
+			p.idToken = tt.idToken
+
+			token, err := p.relyingParty().Exchange(context.Background(), testCode, testVerifier, testNonce)
+
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidIDToken) {
+					t.Fatalf("got error %v; want %v", err, ErrInvalidIDToken)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.Email != "alice@example.com" || !token.EmailVerified || token.Name != "Alice" {
+				t.Errorf("got claims %+v", token)
+			}
+		})
+	}
+}
+
+func TestExchangeRejectedByProvider(t *testing.T) {
+	p := newTestProvider(t)
+
+	_, err := p.relyingParty().Exchange(context.Background(), testCode, "wrong-verifier", testNonce)
+	if err == nil || errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("got error %v; want the token endpoint's error", err)
+	}
+	if !strings.Contains(err.Error(), "400") {
+		t.Errorf("got error %q; want it to mention the status", err)
+	}
+}
+
+func TestExchangeAfterKeyRotation(t *testing.T) {
+	p := newTestProvider(t)
+	rp := p.relyingParty()
+
+	_, err := rp.Exchange(context.Background(), testCode, testVerifier, testNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The provider starts signing with a new key, which the relying party hasn't
+	// seen yet.
+	p.keys = map[string]*rsa.PrivateKey{"key-2": newKey(t)}
+	p.idToken = func(p *testProvider) string {
+		return p.signRS256("key-2", p.claims(nil))
+	}
+
+	_, err = rp.Exchange(context.Background(), testCode, testVerifier, testNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	p := newTestProvider(t)
+
+	rp := New(Config{Issuer: p.server.URL + "/", ClientID: testClientID})
+
+	_, err := rp.AuthCodeURL(context.Background(), "state", testNonce, testVerifier)
+	if err == nil {
+		t.Fatal("got no error for a discovery document from another issuer")
+	}
+}
@@ -0,0 +1,54 @@
+// Package storage keeps uploaded files, such as food images, in a blob store:
+// either a directory on the local filesystem or an S3-compatible bucket.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("storage: blob not found")
+	ErrInvalidKey = errors.New("storage: invalid key")
+
+	// ErrNotSupported is returned by SignedURL when the store can't hand out
+	// direct links, so the blob has to be served through the API instead.
+	ErrNotSupported = errors.New("storage: not supported")
+)
+
+// BlobStore stores blobs under slash-separated keys like "images/12/small".
+type BlobStore interface {
+	// Put stores the contents of r under key, replacing anything already there.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+
+	// Get opens the blob stored under key. It returns ErrNotFound if there
+	// isn't one. The caller must close the blob when done.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Delete removes the blob stored under key. Deleting a blob that doesn't
+	// exist isn't an error.
+	Delete(ctx context.Context, key string) error
+
+	// SignedURL returns a link that can be used to download the blob directly
+	// from the store, without credentials, until it expires.
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// validKey reports whether key is a relative path with no empty, "." or ".."
+// segments, so that it can't escape the store.
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return false
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.Contains(segment, "\\") {
+			return false
+		}
+	}
+
+	return true
+}
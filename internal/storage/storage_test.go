@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"images/12/small", true},
+		{"original", true},
+		{"images/12/.hidden", true},
+		{"", false},
+		{"/images/12/small", false},
+		{"images/12/", false},
+		{"images//small", false},
+		{"images/./small", false},
+		{"images/../small", false},
+		{"..", false},
+		{".", false},
+		{"images/12\\small", false},
+		{"..\\secret", false},
+	}
+
+	for _, tt := range tests {
+		if got := validKey(tt.key); got != tt.want {
+			t.Errorf("validKey(%q) = %t; want %t", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestLocal(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "uploads")
+	ctx := context.Background()
+
+	s, err := NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		contents []byte
+	}{
+		{"top level", "original", []byte("top")},
+		{"nested", "images/12/small", []byte("small")},
+		{"deeply nested", "images/12/sizes/large", bytes.Repeat([]byte("x"), 100_000)},
+		{"empty", "images/13/original", []byte{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Put(ctx, tt.key, bytes.NewReader(tt.contents), "application/octet-stream")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			blob, err := s.Get(ctx, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(blob)
+			blob.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.contents) {
+				t.Errorf("got %d bytes; want %d", len(got), len(tt.contents))
+			}
+
+			// Putting again replaces the blob.
+			err = s.Put(ctx, tt.key, strings.NewReader("replaced"), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			blob, err = s.Get(ctx, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ = io.ReadAll(blob)
+			blob.Close()
+			if string(got) != "replaced" {
+				t.Errorf("got %q after replacing; want %q", got, "replaced")
+			}
+
+			err = s.Delete(ctx, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = s.Get(ctx, tt.key)
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("got error %v after deleting; want %v", err, ErrNotFound)
+			}
+
+			err = s.Delete(ctx, tt.key)
+			if err != nil {
+				t.Errorf("got error %v deleting again", err)
+			}
+		})
+	}
+
+	// Every blob is gone, so the directories they were in should be too, but
+	// not the store's own directory.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d entries left in the store", len(entries))
+	}
+}
+
+func TestLocalDeleteKeepsSiblings(t *testing.T) {
+	s, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, key := range []string{"images/12/small", "images/12/large"} {
+		err = s.Put(ctx, key, strings.NewReader(key), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = s.Delete(ctx, "images/12/small")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := s.Get(ctx, "images/12/large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob.Close()
+}
+
+func TestLocalInvalidKey(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewLocal(filepath.Join(dir, "uploads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, key := range []string{"../outside", "/etc/passwd", "images/../../outside", ""} {
+		if err := s.Put(ctx, key, strings.NewReader("x"), ""); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q): got error %v; want %v", key, err, ErrInvalidKey)
+		}
+		if _, err := s.Get(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q): got error %v; want %v", key, err, ErrInvalidKey)
+		}
+		if err := s.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q): got error %v; want %v", key, err, ErrInvalidKey)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "outside")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("got a file written outside the store")
+	}
+}
+
+func TestLocalSignedURL(t *testing.T) {
+	s, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.SignedURL(context.Background(), "images/12/small", time.Minute)
+	if !errors.Is(err, ErrNotSupported) {
+		t.Errorf("got error %v; want %v", err, ErrNotSupported)
+	}
+}
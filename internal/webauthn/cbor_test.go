@@ -0,0 +1,180 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// cborMap is a CBOR map for encodeCBOR, kept as a list so that the encoding is
+// in a fixed order.
+type cborMap [][2]any
+
+// encodeCBOR encodes the subset of CBOR that decodeCBOR understands. It is only
+// used to build authenticator responses in tests.
+func encodeCBOR(value any) []byte {
+	switch v := value.(type) {
+	case int64:
+		if v >= 0 {
+			return cborHead(0, uint64(v))
+		}
+		return cborHead(1, uint64(-1-v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []any:
+		b := cborHead(4, uint64(len(v)))
+		for _, item := range v {
+			b = append(b, encodeCBOR(item)...)
+		}
+		return b
+	case cborMap:
+		b := cborHead(5, uint64(len(v)))
+		for _, pair := range v {
+			b = append(b, encodeCBOR(pair[0])...)
+			b = append(b, encodeCBOR(pair[1])...)
+		}
+		return b
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case nil:
+		return []byte{0xf6}
+	}
+
+	panic("encodeCBOR: unsupported type")
+}
+
+func cborHead(major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return []byte{major | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major | 25}, uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major | 26}, uint32(arg))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major | 27}, arg)
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  any
+	}{
+		{"small integer", []byte{0x17}, int64(23)},
+		{"one byte integer", []byte{0x18, 0x18}, int64(24)},
+		{"two byte integer", []byte{0x19, 0x03, 0xe8}, int64(1000)},
+		{"four byte integer", []byte{0x1a, 0x00, 0x0f, 0x42, 0x40}, int64(1000000)},
+		{"eight byte integer", []byte{0x1b, 0, 0, 0, 0xe8, 0xd4, 0xa5, 0x10, 0x00}, int64(1000000000000)},
+		{"negative integer", []byte{0x26}, int64(-7)},
+		{"large negative integer", []byte{0x39, 0x01, 0x00}, int64(-257)},
+		{"byte string", []byte{0x43, 1, 2, 3}, []byte{1, 2, 3}},
+		{"text string", []byte{0x64, 'n', 'o', 'n', 'e'}, "none"},
+		{"array", []byte{0x82, 0x01, 0x20}, []any{int64(1), int64(-1)}},
+		{"map", []byte{0xa2, 0x01, 0x02, 0x63, 'f', 'm', 't', 0xf5}, map[any]any{int64(1): int64(2), "fmt": true}},
+		{"tag", []byte{0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0}, int64(1363896240)},
+		{"false", []byte{0xf4}, false},
+		{"null", []byte{0xf6}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rest, err := decodeCBOR(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v; want %#v", got, tt.want)
+			}
+			if len(rest) != 0 {
+				t.Errorf("got %d bytes left over", len(rest))
+			}
+		})
+	}
+}
+
+func TestDecodeCBORRest(t *testing.T) {
+	got, rest, err := decodeCBOR([]byte{0x01, 0x02, 0x03})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != int64(1) || !bytes.Equal(rest, []byte{0x02, 0x03}) {
+		t.Errorf("got %v with %x left over", got, rest)
+	}
+}
+
+func TestDecodeCBORRoundTrip(t *testing.T) {
+	value := cborMap{
+		{"fmt", "none"},
+		{"attStmt", cborMap{}},
+		{"authData", bytes.Repeat([]byte{0xab}, 300)},
+		{int64(-70000), []any{int64(1 << 40), false, nil}},
+	}
+
+	got, _, err := decodeCBOR(encodeCBOR(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[any]any{
+		"fmt":         "none",
+		"attStmt":     map[any]any{},
+		"authData":    bytes.Repeat([]byte{0xab}, 300),
+		int64(-70000): []any{int64(1 << 40), false, nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
+
+func TestDecodeCBORMalformed(t *testing.T) {
+	deep := bytes.Repeat([]byte{0x81}, 20)
+	deep = append(deep, 0x01)
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"empty", []byte{}},
+		{"truncated argument", []byte{0x19, 0x03}},
+		{"truncated byte string", []byte{0x45, 1, 2}},
+		{"truncated text string", []byte{0x65, 'a'}},
+		{"truncated array", []byte{0x83, 0x01, 0x02}},
+		{"truncated map", []byte{0xa1, 0x01}},
+		{"length larger than input", []byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"array length larger than input", []byte{0x9a, 0xff, 0xff, 0xff, 0xff}},
+		{"map length larger than input", []byte{0xba, 0xff, 0xff, 0xff, 0xff}},
+		{"indefinite length byte string", []byte{0x5f, 0x41, 0x01, 0xff}},
+		{"indefinite length array", []byte{0x9f, 0x01, 0xff}},
+		{"reserved additional information", []byte{0x1c}},
+		{"integer overflow", []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"negative integer overflow", []byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"array map key", []byte{0xa1, 0x80, 0x01}},
+		{"byte string map key", []byte{0xa1, 0x41, 0x01, 0x01}},
+		{"float", []byte{0xf9, 0x3c, 0x00}},
+		{"undefined", []byte{0xf7}},
+		{"break outside indefinite item", []byte{0xff}},
+		{"nested too deeply", deep},
+		{"tag without content", []byte{0xc1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decodeCBOR(tt.input)
+			if !errors.Is(err, errInvalidCBOR) {
+				t.Fatalf("got error %v; want %v", err, errInvalidCBOR)
+			}
+		})
+	}
+}
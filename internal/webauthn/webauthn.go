@@ -0,0 +1,342 @@
+// Package webauthn implements the server side of WebAuthn passkey registration
+// and login. It only accepts the "none" attestation format and ES256 keys, which
+// every current platform authenticator supports.
+//
+// Everything works on the raw bytes sent by the browser, so the ceremonies can
+// be driven by a software authenticator without a browser.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrInvalidResponse = errors.New("webauthn: invalid authenticator response")
+	ErrUnsupportedKey  = errors.New("webauthn: unsupported credential public key")
+	// ErrSignCount means the authenticator's signature counter went backwards,
+	// which suggests the credential has been cloned.
+	ErrSignCount = errors.New("webauthn: signature counter did not increase")
+)
+
+const (
+	flagUserPresent     = 0x01
+	flagUserVerified    = 0x04
+	flagAttestedCredKey = 0x40
+)
+
+// Buffer is a byte slice that is sent to and from the browser as unpadded
+// base64url, as the WebAuthn JSON encoding expects.
+type Buffer []byte
+
+func (b Buffer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Buffer) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+
+	*b = decoded
+	return nil
+}
+
+type Config struct {
+	RPID   string
+	RPName string
+	Origin string
+}
+
+// RelyingParty checks registration and login responses for one site.
+type RelyingParty struct {
+	config Config
+}
+
+func New(config Config) *RelyingParty {
+	return &RelyingParty{config: config}
+}
+
+// Credential is what needs to be stored for a newly registered passkey.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+}
+
+type User struct {
+	ID          Buffer `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credentialParameters struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   Buffer `json:"id"`
+}
+
+// CreationOptions is passed to navigator.credentials.create() in the browser.
+type CreationOptions struct {
+	Challenge Buffer `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User                   User                   `json:"user"`
+	PubKeyCredParams       []credentialParameters `json:"pubKeyCredParams"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+	Timeout     int    `json:"timeout"`
+}
+
+// RequestOptions is passed to navigator.credentials.get() in the browser.
+type RequestOptions struct {
+	Challenge        Buffer                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+	Timeout          int                    `json:"timeout"`
+}
+
+func (rp *RelyingParty) CreationOptions(challenge []byte, user User, excludeIDs [][]byte) CreationOptions {
+	var options CreationOptions
+	options.Challenge = challenge
+	options.RP.ID = rp.config.RPID
+	options.RP.Name = rp.config.RPName
+	options.User = user
+	options.PubKeyCredParams = []credentialParameters{{Type: "public-key", Alg: -7}}
+	options.ExcludeCredentials = descriptors(excludeIDs)
+	options.AuthenticatorSelection.ResidentKey = "preferred"
+	options.AuthenticatorSelection.UserVerification = "required"
+	options.Attestation = "none"
+	options.Timeout = 300000
+	return options
+}
+
+func (rp *RelyingParty) RequestOptions(challenge []byte, allowIDs [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.config.RPID,
+		AllowCredentials: descriptors(allowIDs),
+		UserVerification: "required",
+		Timeout:          300000,
+	}
+}
+
+func descriptors(ids [][]byte) []credentialDescriptor {
+	list := []credentialDescriptor{}
+	for _, id := range ids {
+		list = append(list, credentialDescriptor{Type: "public-key", ID: id})
+	}
+	return list
+}
+
+// ClientData is the decoded clientDataJSON that the browser signs over.
+type ClientData struct {
+	Type      string `json:"type"`
+	Challenge Buffer `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// ParseClientData decodes clientDataJSON. Callers use the challenge in it to
+// find the ceremony that the response belongs to.
+func ParseClientData(clientDataJSON []byte) (*ClientData, error) {
+	var clientData ClientData
+	err := json.Unmarshal(clientDataJSON, &clientData)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	return &clientData, nil
+}
+
+// VerifyRegistration checks the response to navigator.credentials.create() and
+// returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	err := rp.checkClientData(clientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	attestation, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+
+	// We ask for "none" attestation, so there must be no statement to check.
+	statement, _ := attestation["attStmt"].(map[any]any)
+	if attestation["fmt"] != "none" || len(statement) != 0 {
+		return nil, ErrInvalidResponse
+	}
+
+	authData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+
+	flags, signCount, rest, err := rp.parseAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagAttestedCredKey == 0 || len(rest) < 18 {
+		return nil, ErrInvalidResponse
+	}
+
+	// Skip the 16-byte AAGUID, then read the length-prefixed credential ID and
+	// the COSE public key that follows it.
+	rest = rest[16:]
+	idLength := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+		return nil, ErrInvalidResponse
+	}
+	credentialID := rest[:idLength]
+	rest = rest[idLength:]
+
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	publicKey := rest[:len(rest)-len(after)]
+
+	_, err = parsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        append([]byte(nil), credentialID...),
+		PublicKey: append([]byte(nil), publicKey...),
+		SignCount: signCount,
+	}, nil
+}
+
+// VerifyAssertion checks the response to navigator.credentials.get() against a
+// stored credential, and returns the authenticator's new signature counter.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, credential Credential, clientDataJSON, authenticatorData, signature []byte) (uint32, error) {
+	err := rp.checkClientData(clientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	_, signCount, _, err := rp.parseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	publicKey, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authenticatorData...), clientDataHash[:]...))
+	if !ecdsa.VerifyASN1(publicKey, digest[:], signature) {
+		return 0, ErrInvalidResponse
+	}
+
+	// Authenticators that don't keep a counter always report zero.
+	if (signCount != 0 || credential.SignCount != 0) && signCount <= credential.SignCount {
+		return 0, ErrSignCount
+	}
+
+	return signCount, nil
+}
+
+func (rp *RelyingParty) checkClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	clientData, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case clientData.Type != ceremony:
+		return ErrInvalidResponse
+	case subtle.ConstantTimeCompare(clientData.Challenge, challenge) != 1:
+		return ErrInvalidResponse
+	case clientData.Origin != rp.config.Origin:
+		return ErrInvalidResponse
+	}
+
+	return nil
+}
+
+// parseAuthenticatorData checks the relying party ID hash and flags at the start
+// of the authenticator data, and returns the flags, counter and remaining bytes.
+func (rp *RelyingParty) parseAuthenticatorData(authData []byte) (byte, uint32, []byte, error) {
+	if len(authData) < 37 {
+		return 0, 0, nil, ErrInvalidResponse
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.config.RPID))
+	if !bytes.Equal(authData[:32], rpIDHash[:]) {
+		return 0, 0, nil, ErrInvalidResponse
+	}
+
+	flags := authData[32]
+	if flags&flagUserPresent == 0 || flags&flagUserVerified == 0 {
+		return 0, 0, nil, ErrInvalidResponse
+	}
+
+	return flags, binary.BigEndian.Uint32(authData[33:37]), authData[37:], nil
+}
+
+// parsePublicKey reads a COSE-encoded ES256 (P-256) public key.
+func parsePublicKey(coseKey []byte) (*ecdsa.PublicKey, error) {
+	decoded, _, err := decodeCBOR(coseKey)
+	if err != nil {
+		return nil, ErrUnsupportedKey
+	}
+	key, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+
+	// COSE labels: 1 = kty (2 is EC2), 3 = alg (-7 is ES256), -1 = crv (1 is
+	// P-256), -2 and -3 = the x and y coordinates.
+	x, xOK := key[int64(-2)].([]byte)
+	y, yOK := key[int64(-3)].([]byte)
+	if key[int64(1)] != int64(2) || key[int64(3)] != int64(-7) || key[int64(-1)] != int64(1) ||
+		!xOK || !yOK || len(x) != 32 || len(y) != 32 {
+		return nil, ErrUnsupportedKey
+	}
+
+	publicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return nil, ErrUnsupportedKey
+	}
+
+	return publicKey, nil
+}
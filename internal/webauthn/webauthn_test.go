@@ -0,0 +1,513 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// softAuthenticator is a software stand-in for a platform authenticator with a
+// single ES256 credential. Its fields can be changed between ceremonies to make
+// it misbehave.
+type softAuthenticator struct {
+	t          *testing.T
+	key        *ecdsa.PrivateKey
+	credential []byte
+	signCount  uint32
+
+	rpID   string
+	origin string
+	flags  byte
+
+	// counting is false for authenticators that don't keep a signature counter
+	// and always report zero.
+	counting bool
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential := make([]byte, 16)
+	_, err = rand.Read(credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{
+		t:          t,
+		key:        key,
+		credential: credential,
+		rpID:       testRPID,
+		origin:     testOrigin,
+		flags:      flagUserPresent | flagUserVerified,
+		counting:   true,
+	}
+}
+
+func newRelyingParty() *RelyingParty {
+	return New(Config{RPID: testRPID, RPName: "Greenlight", Origin: testOrigin})
+}
+
+func newChallenge(t *testing.T) []byte {
+	t.Helper()
+
+	challenge := make([]byte, 32)
+	_, err := rand.Read(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	clientDataJSON, err := json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return clientDataJSON
+}
+
+func (a *softAuthenticator) authenticatorData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+
+	authData := append([]byte(nil), rpIDHash[:]...)
+	authData = append(authData, flags)
+	authData = binary.BigEndian.AppendUint32(authData, a.signCount)
+	return append(authData, attested...)
+}
+
+// coseKey encodes the credential's public key as a COSE EC2 key.
+func (a *softAuthenticator) coseKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+
+	return encodeCBOR(cborMap{
+		{int64(1), int64(2)},
+		{int64(3), int64(-7)},
+		{int64(-1), int64(1)},
+		{int64(-2), x},
+		{int64(-3), y},
+	})
+}
+
+// register answers navigator.credentials.create().
+func (a *softAuthenticator) register(challenge []byte) (clientDataJSON, attestationObject []byte) {
+	attested := make([]byte, 16) // An all-zero AAGUID, as "none" attestation allows.
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credential)))
+	attested = append(attested, a.credential...)
+	attested = append(attested, a.coseKey()...)
+
+	attestationObject = encodeCBOR(cborMap{
+		{"fmt", "none"},
+		{"attStmt", cborMap{}},
+		{"authData", a.authenticatorData(a.flags|flagAttestedCredKey, attested)},
+	})
+
+	return a.clientData("webauthn.create", challenge), attestationObject
+}
+
+// assert answers navigator.credentials.get(), counting the signature if the
+// authenticator keeps a counter.
+func (a *softAuthenticator) assert(challenge []byte) (clientDataJSON, authenticatorData, signature []byte) {
+	if a.counting {
+		a.signCount++
+	}
+
+	clientDataJSON = a.clientData("webauthn.get", challenge)
+	authenticatorData = a.authenticatorData(a.flags, nil)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authenticatorData...), clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return clientDataJSON, authenticatorData, signature
+}
+
+// registerCredential runs a successful registration ceremony.
+func registerCredential(t *testing.T, rp *RelyingParty, a *softAuthenticator) *Credential {
+	t.Helper()
+
+	challenge := newChallenge(t)
+	clientDataJSON, attestationObject := a.register(challenge)
+
+	credential, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return credential
+}
+
+func TestRegistrationAndLogin(t *testing.T) {
+	rp := newRelyingParty()
+	a := newSoftAuthenticator(t)
+
+	credential := registerCredential(t, rp, a)
+
+	if !bytes.Equal(credential.ID, a.credential) {
+		t.Errorf("got credential ID %x; want %x", credential.ID, a.credential)
+	}
+	if credential.SignCount != 0 {
+		t.Errorf("got sign count %d; want 0", credential.SignCount)
+	}
+
+	for want := uint32(1); want <= 3; want++ {
+		challenge := newChallenge(t)
+		clientDataJSON, authenticatorData, signature := a.assert(challenge)
+
+		signCount, err := rp.VerifyAssertion(challenge, *credential, clientDataJSON, authenticatorData, signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signCount != want {
+			t.Errorf("got sign count %d; want %d", signCount, want)
+		}
+		credential.SignCount = signCount
+	}
+}
+
+func TestRegistrationRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(a *softAuthenticator, challenge []byte) (challengeSent, clientDataJSON, attestationObject []byte)
+	}{
+		{
+			name: "wrong origin",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte) {
+				a.origin = "https://evil.example.net"
+				clientDataJSON, attestationObject := a.register(challenge)
+				return challenge, clientDataJSON, attestationObject
+			},
+		},
+		{
+			name: "wrong rpIdHash",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte) {
+				a.rpID = "evil.example.net"
+				clientDataJSON, attestationObject := a.register(challenge)
+				return challenge, clientDataJSON, attestationObject
+			},
+		},
+		{
+			name: "user presence flag missing",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte) {
+				a.flags = flagUserVerified
+				clientDataJSON, attestationObject := a.register(challenge)
+				return challenge, clientDataJSON, attestationObject
+			},
+		},
+		{
+			name: "user verification flag missing",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte) {
+				a.flags = flagUserPresent
+				clientDataJSON, attestationObject := a.register(challenge)
+				return challenge, clientDataJSON, attestationObject
+			},
+		},
+		{
+			name: "wrong challenge",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte) {
+				clientDataJSON, attestationObject := a.register(newChallenge(a.t))
+				return challenge, clientDataJSON, attestationObject
+			},
+		},
+		{
+			name: "login response",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte) {
+				_, attestationObject := a.register(challenge)
+				return challenge, a.clientData("webauthn.get", challenge), attestationObject
+			},
+		},
+		{
+			name: "attestation statement present",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte) {
+				clientDataJSON, _ := a.register(challenge)
+				attestationObject := encodeCBOR(cborMap{
+					{"fmt", "packed"},
+					{"attStmt", cborMap{{"alg", int64(-7)}, {"sig", []byte{1, 2, 3}}}},
+					{"authData", a.authenticatorData(a.flags|flagAttestedCredKey, nil)},
+				})
+				return challenge, clientDataJSON, attestationObject
+			},
+		},
+		{
+			name: "no attested credential",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte) {
+				clientDataJSON, _ := a.register(challenge)
+				attestationObject := encodeCBOR(cborMap{
+					{"fmt", "none"},
+					{"attStmt", cborMap{}},
+					{"authData", a.authenticatorData(a.flags, nil)},
+				})
+				return challenge, clientDataJSON, attestationObject
+			},
+		},
+		{
+			name: "malformed attestation object",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte) {
+				clientDataJSON, attestationObject := a.register(challenge)
+				return challenge, clientDataJSON, attestationObject[:len(attestationObject)/2]
+			},
+		},
+		{
+			name: "malformed client data",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte) {
+				_, attestationObject := a.register(challenge)
+				return challenge, []byte("{"), attestationObject
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newRelyingParty()
+			a := newSoftAuthenticator(t)
+
+			challenge, clientDataJSON, attestationObject := tt.modify(a, newChallenge(t))
+
+			_, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+			if !errors.Is(err, ErrInvalidResponse) {
+				t.Fatalf("got error %v; want %v", err, ErrInvalidResponse)
+			}
+		})
+	}
+}
+
+func TestRegistrationUnsupportedKey(t *testing.T) {
+	rp := newRelyingParty()
+	a := newSoftAuthenticator(t)
+
+	challenge := newChallenge(t)
+	clientDataJSON, _ := a.register(challenge)
+
+	// An RS256 key, which isn't supported.
+	rsaKey := encodeCBOR(cborMap{
+		{int64(1), int64(3)},
+		{int64(3), int64(-257)},
+		{int64(-1), bytes.Repeat([]byte{1}, 256)},
+		{int64(-2), []byte{1, 0, 1}},
+	})
+
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credential)))
+	attested = append(attested, a.credential...)
+	attested = append(attested, rsaKey...)
+
+	attestationObject := encodeCBOR(cborMap{
+		{"fmt", "none"},
+		{"attStmt", cborMap{}},
+		{"authData", a.authenticatorData(a.flags|flagAttestedCredKey, attested)},
+	})
+
+	_, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if !errors.Is(err, ErrUnsupportedKey) {
+		t.Fatalf("got error %v; want %v", err, ErrUnsupportedKey)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(a *softAuthenticator, challenge []byte) (challengeSent, clientDataJSON, authenticatorData, signature []byte)
+		wantErr error
+	}{
+		{
+			name: "wrong origin",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte, []byte) {
+				a.origin = "https://evil.example.net"
+				clientDataJSON, authenticatorData, signature := a.assert(challenge)
+				return challenge, clientDataJSON, authenticatorData, signature
+			},
+			wantErr: ErrInvalidResponse,
+		},
+		{
+			name: "wrong rpIdHash",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte, []byte) {
+				a.rpID = "evil.example.net"
+				clientDataJSON, authenticatorData, signature := a.assert(challenge)
+				return challenge, clientDataJSON, authenticatorData, signature
+			},
+			wantErr: ErrInvalidResponse,
+		},
+		{
+			name: "user presence flag missing",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte, []byte) {
+				a.flags = flagUserVerified
+				clientDataJSON, authenticatorData, signature := a.assert(challenge)
+				return challenge, clientDataJSON, authenticatorData, signature
+			},
+			wantErr: ErrInvalidResponse,
+		},
+		{
+			name: "user verification flag missing",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte, []byte) {
+				a.flags = flagUserPresent
+				clientDataJSON, authenticatorData, signature := a.assert(challenge)
+				return challenge, clientDataJSON, authenticatorData, signature
+			},
+			wantErr: ErrInvalidResponse,
+		},
+		{
+			name: "wrong challenge",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte, []byte) {
+				clientDataJSON, authenticatorData, signature := a.assert(newChallenge(a.t))
+				return challenge, clientDataJSON, authenticatorData, signature
+			},
+			wantErr: ErrInvalidResponse,
+		},
+		{
+			name: "registration response",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte, []byte) {
+				_, authenticatorData, signature := a.assert(challenge)
+				return challenge, a.clientData("webauthn.create", challenge), authenticatorData, signature
+			},
+			wantErr: ErrInvalidResponse,
+		},
+		{
+			name: "tampered authenticator data",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte, []byte) {
+				clientDataJSON, authenticatorData, signature := a.assert(challenge)
+				authenticatorData[36]++
+				return challenge, clientDataJSON, authenticatorData, signature
+			},
+			wantErr: ErrInvalidResponse,
+		},
+		{
+			name: "signed by another key",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte, []byte) {
+				a.key = newSoftAuthenticator(a.t).key
+				clientDataJSON, authenticatorData, signature := a.assert(challenge)
+				return challenge, clientDataJSON, authenticatorData, signature
+			},
+			wantErr: ErrInvalidResponse,
+		},
+		{
+			name: "truncated authenticator data",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte, []byte) {
+				clientDataJSON, authenticatorData, signature := a.assert(challenge)
+				return challenge, clientDataJSON, authenticatorData[:36], signature
+			},
+			wantErr: ErrInvalidResponse,
+		},
+		{
+			name: "sign count regression",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte, []byte) {
+				a.signCount = 2 // The stored count is 5, so this signs with 3.
+				clientDataJSON, authenticatorData, signature := a.assert(challenge)
+				return challenge, clientDataJSON, authenticatorData, signature
+			},
+			wantErr: ErrSignCount,
+		},
+		{
+			name: "sign count repeated",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte, []byte) {
+				a.signCount = 4
+				clientDataJSON, authenticatorData, signature := a.assert(challenge)
+				return challenge, clientDataJSON, authenticatorData, signature
+			},
+			wantErr: ErrSignCount,
+		},
+		{
+			name: "sign count reset to zero",
+			modify: func(a *softAuthenticator, challenge []byte) ([]byte, []byte, []byte, []byte) {
+				a.counting = false
+				a.signCount = 0
+				clientDataJSON, authenticatorData, signature := a.assert(challenge)
+				return challenge, clientDataJSON, authenticatorData, signature
+			},
+			wantErr: ErrSignCount,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newRelyingParty()
+			a := newSoftAuthenticator(t)
+
+			credential := registerCredential(t, rp, a)
+			credential.SignCount = 5
+			a.signCount = 5
+
+			challenge, clientDataJSON, authenticatorData, signature := tt.modify(a, newChallenge(t))
+
+			_, err := rp.VerifyAssertion(challenge, *credential, clientDataJSON, authenticatorData, signature)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoginWithoutSignCounter(t *testing.T) {
+	rp := newRelyingParty()
+	a := newSoftAuthenticator(t)
+
+	a.counting = false
+
+	credential := registerCredential(t, rp, a)
+
+	// Authenticators that don't count signatures always report zero, which is
+	// allowed as long as the stored count is zero too.
+	for i := 0; i < 2; i++ {
+		challenge := newChallenge(t)
+		clientDataJSON, authenticatorData, signature := a.assert(challenge)
+
+		signCount, err := rp.VerifyAssertion(challenge, *credential, clientDataJSON, authenticatorData, signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signCount != 0 {
+			t.Errorf("got sign count %d; want 0", signCount)
+		}
+	}
+}
+
+func TestBufferJSON(t *testing.T) {
+	b := Buffer{0xfb, 0xff}
+
+	encoded, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != `"-_8"` {
+		t.Errorf("got %s; want %q", encoded, "-_8")
+	}
+
+	// Padded input is accepted too.
+	for _, input := range []string{`"-_8"`, `"-_8="`} {
+		var decoded Buffer
+		err = json.Unmarshal([]byte(input), &decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, b) {
+			t.Errorf("got %x; want %x", decoded, b)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial PRIMARY KEY,
+    code text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS users_permissions (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (user_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES ('foods:write'), ('sales:write'), ('users:manage')
+ON CONFLICT DO NOTHING;
+
+-- Existing admins keep the access that the role check used to give them.
+INSERT INTO users_permissions
+SELECT users.id, permissions.id FROM users, permissions
+WHERE users.role = 'admin'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,6 @@
+ALTER TABLE audit_events DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE users DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE sales DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE foods DROP COLUMN IF EXISTS organization_id;
+
+DROP TABLE IF EXISTS organizations;
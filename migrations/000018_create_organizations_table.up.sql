@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    slug citext UNIQUE NOT NULL,
+    -- Food schedules are in the restaurant's local time. Names are from the
+    -- IANA time zone database, e.g. Asia/Almaty.
+    time_zone text NOT NULL DEFAULT 'UTC',
+    version integer NOT NULL DEFAULT 1
+);
+
+-- Everything that existed before organizations were introduced belongs to the
+-- default organization.
+INSERT INTO organizations (id, name, slug)
+VALUES (1, 'Default', 'default')
+ON CONFLICT DO NOTHING;
+
+SELECT setval('organizations_id_seq', (SELECT MAX(id) FROM organizations));
+
+ALTER TABLE foods ADD COLUMN IF NOT EXISTS organization_id bigint NOT NULL DEFAULT 1 REFERENCES organizations ON DELETE CASCADE;
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS organization_id bigint NOT NULL DEFAULT 1 REFERENCES organizations ON DELETE CASCADE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS organization_id bigint NOT NULL DEFAULT 1 REFERENCES organizations ON DELETE CASCADE;
+
+ALTER TABLE foods ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE sales ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE users ALTER COLUMN organization_id DROP DEFAULT;
+
+CREATE INDEX IF NOT EXISTS foods_organization_id_idx ON foods (organization_id);
+CREATE INDEX IF NOT EXISTS sales_organization_id_idx ON sales (organization_id);
+CREATE INDEX IF NOT EXISTS users_organization_id_idx ON users (organization_id);
+
+-- Audit events belong to the organization the request was for. Events recorded
+-- before organizations existed were all for the default organization.
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS organization_id bigint REFERENCES organizations ON DELETE CASCADE;
+
+UPDATE audit_events SET organization_id = 1;
+
+CREATE INDEX IF NOT EXISTS audit_events_organization_id_idx ON audit_events (organization_id);
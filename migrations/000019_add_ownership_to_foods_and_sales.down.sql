@@ -0,0 +1,4 @@
+ALTER TABLE sales DROP COLUMN IF EXISTS updated_by;
+ALTER TABLE sales DROP COLUMN IF EXISTS created_by;
+ALTER TABLE foods DROP COLUMN IF EXISTS updated_by;
+ALTER TABLE foods DROP COLUMN IF EXISTS created_by;
@@ -0,0 +1,6 @@
+-- Rows created before ownership was tracked have no creator, so only managers
+-- and admins can edit them.
+ALTER TABLE foods ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users ON DELETE SET NULL;
+ALTER TABLE foods ADD COLUMN IF NOT EXISTS updated_by bigint REFERENCES users ON DELETE SET NULL;
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users ON DELETE SET NULL;
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS updated_by bigint REFERENCES users ON DELETE SET NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS foods_categories;
+DROP TABLE IF EXISTS categories;
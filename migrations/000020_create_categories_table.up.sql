@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS categories (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    parent_id bigint REFERENCES categories ON DELETE CASCADE,
+    name text NOT NULL,
+    sort_order integer NOT NULL DEFAULT 0,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS categories_organization_id_idx ON categories (organization_id);
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id);
+
+CREATE TABLE IF NOT EXISTS foods_categories (
+    food_id bigint NOT NULL REFERENCES foods ON DELETE CASCADE,
+    category_id bigint NOT NULL REFERENCES categories ON DELETE CASCADE,
+    PRIMARY KEY (food_id, category_id)
+);
+
+CREATE INDEX IF NOT EXISTS foods_categories_category_id_idx ON foods_categories (category_id);
@@ -0,0 +1,14 @@
+ALTER TABLE foods ADD COLUMN IF NOT EXISTS recipe text[] NOT NULL DEFAULT '{}';
+
+UPDATE foods SET recipe = ARRAY(
+    SELECT ingredients.name::text
+    FROM foods_ingredients
+    INNER JOIN ingredients ON ingredients.id = foods_ingredients.ingredient_id
+    WHERE foods_ingredients.food_id = foods.id
+    ORDER BY ingredients.name
+);
+
+ALTER TABLE foods ALTER COLUMN recipe DROP DEFAULT;
+
+DROP TABLE IF EXISTS foods_ingredients;
+DROP TABLE IF EXISTS ingredients;
@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS ingredients (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    name citext NOT NULL,
+    -- NULL means the ingredient hasn't been classified yet, as opposed to an
+    -- empty list, which means it has none.
+    allergens text[],
+    diets text[],
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (organization_id, name)
+);
+
+-- Ingredients can't be deleted while a food uses them, so a food can never
+-- silently lose an allergen.
+CREATE TABLE IF NOT EXISTS foods_ingredients (
+    food_id bigint NOT NULL REFERENCES foods ON DELETE CASCADE,
+    ingredient_id bigint NOT NULL REFERENCES ingredients ON DELETE RESTRICT,
+    PRIMARY KEY (food_id, ingredient_id)
+);
+
+CREATE INDEX IF NOT EXISTS foods_ingredients_ingredient_id_idx ON foods_ingredients (ingredient_id);
+
+-- Turn the free-text recipes into ingredients. Names differing only in case or
+-- surrounding spaces become one ingredient. They start out unclassified, and
+-- their allergens and diets need filling in by staff.
+INSERT INTO ingredients (organization_id, name)
+SELECT DISTINCT foods.organization_id, btrim(recipe.name)::citext
+FROM foods, unnest(foods.recipe) AS recipe(name)
+WHERE btrim(recipe.name) <> ''
+ON CONFLICT DO NOTHING;
+
+INSERT INTO foods_ingredients (food_id, ingredient_id)
+SELECT DISTINCT foods.id, ingredients.id
+FROM foods, unnest(foods.recipe) AS recipe(name)
+INNER JOIN ingredients ON ingredients.name = btrim(recipe.name)::citext
+WHERE ingredients.organization_id = foods.organization_id
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE foods DROP COLUMN IF EXISTS recipe;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS food_images (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    food_id bigint NOT NULL REFERENCES foods ON DELETE CASCADE,
+    content_type text NOT NULL,
+    width integer NOT NULL,
+    height integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS food_images_food_id_idx ON food_images (food_id);
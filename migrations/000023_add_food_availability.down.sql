@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS food_schedules;
+
+ALTER TABLE foods DROP COLUMN IF EXISTS available;
@@ -0,0 +1,16 @@
+ALTER TABLE foods ADD COLUMN IF NOT EXISTS available boolean NOT NULL DEFAULT true;
+
+-- A food with no schedule windows can be ordered at any time while it is
+-- available. Times are minutes since midnight in the restaurant's time zone,
+-- and days are numbered from Sunday (0) to Saturday (6). A window whose end is
+-- earlier than its start runs overnight, ending on the day after the one it
+-- starts on.
+CREATE TABLE IF NOT EXISTS food_schedules (
+    id bigserial PRIMARY KEY,
+    food_id bigint NOT NULL REFERENCES foods ON DELETE CASCADE,
+    days smallint[] NOT NULL CHECK (days <@ ARRAY[0,1,2,3,4,5,6]::smallint[]),
+    starts_at integer NOT NULL CHECK (starts_at >= 0 AND starts_at < 1440),
+    ends_at integer NOT NULL CHECK (ends_at >= 0 AND ends_at <= 1440 AND ends_at <> starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS food_schedules_food_id_idx ON food_schedules (food_id);
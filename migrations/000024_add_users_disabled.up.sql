@@ -0,0 +1,4 @@
+-- Accounts that an admin has turned off. This is kept apart from activated,
+-- which only says whether the owner has confirmed their email address, so that
+-- nothing the owner can do by themselves turns the account back on.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled bool NOT NULL DEFAULT false;